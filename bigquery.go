@@ -0,0 +1,312 @@
+package idharvest
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// chunkSize is the number of rows sent to BigQuery in one request, and
+// chunkInterval the pause between requests.
+const (
+	chunkSize     = 5000
+	chunkInterval = 2000 * time.Millisecond
+)
+
+// BigQuerySink stores harvested data in a BigQuery dataset, see Sink. It
+// creates missing tables and columns as needed, and the rollups of
+// RollupPeriods as materialized views.
+type BigQuerySink struct {
+	client  *bigquery.Client
+	dataset *bigquery.Dataset
+}
+
+// NewBigQuerySink returns a sink writing to dataset in project. Close it
+// when done.
+func NewBigQuerySink(ctx context.Context, project string, dataset string) (*BigQuerySink, error) {
+	client, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	return &BigQuerySink{client: client, dataset: client.Dataset(dataset)}, nil
+}
+
+// Close closes the BigQuery client.
+func (s *BigQuerySink) Close() error {
+	return s.client.Close()
+}
+
+// Latest implements Sink.
+func (s *BigQuerySink) Latest(ctx context.Context) (time.Time, error) {
+	q := s.client.Query(fmt.Sprintf(
+		"SELECT MAX(timestamp) AS timestamp FROM `%s.%s.%s`",
+		s.dataset.ProjectID, s.dataset.DatasetID, MetricsTableName))
+	it, err := q.Read(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// MAX of an empty table is NULL, which leaves the timestamp zero.
+	var latest Metric
+	if err := it.Next(&latest); err != nil {
+		return time.Time{}, err
+	}
+	return latest.Timestamp, nil
+}
+
+// WriteSeries implements Sink.
+func (s *BigQuerySink) WriteSeries(ctx context.Context, series Series) error {
+	schema, err := StatistikkSchema()
+	if err != nil {
+		return err
+	}
+	ref := s.dataset.Table(tableName)
+	if err := ensureTable(ctx, ref, schema); err != nil {
+		return err
+	}
+	work := SplitStatistikkArrayIntoChunks(series, chunkSize)
+	for i := range work {
+		if i > 0 {
+			log.Printf("Submitting %v of %v parts, this one has  %v rows", i, len(work), len(work[i]))
+			if err := wait(ctx, time.After(chunkInterval)); err != nil {
+				return err
+			}
+		}
+		if err := ref.Inserter().Put(ctx, work[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMetrics implements Sink.
+func (s *BigQuerySink) WriteMetrics(ctx context.Context, table string, metrics []Metric) error {
+	ref := s.dataset.Table(table)
+	if err := ensureTable(ctx, ref, MetricSchema()); err != nil {
+		return err
+	}
+	work := SplitMetricArrayIntoChunks(metrics, chunkSize)
+	for i := range work {
+		if i > 0 {
+			log.Printf("Submitting %v of %v metric parts to %v, this one has  %v rows", i, len(work), table, len(work[i]))
+			if err := wait(ctx, time.After(chunkInterval)); err != nil {
+				return err
+			}
+		}
+		if err := ref.Inserter().Put(ctx, work[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset implements Sink. It creates the dataset if it doesn't exist and
+// deletes the tables and their rollups, which are created again on the
+// first write and Flush.
+func (s *BigQuerySink) Reset(ctx context.Context) error {
+	md, err := s.dataset.Metadata(ctx)
+	if err != nil {
+		meta := &bigquery.DatasetMetadata{
+			Description: "Statistikk om innlogginger fra idporten",
+			Location:    "EU", // See https://cloud.google.com/bigquery/docs/locations
+		}
+		return s.dataset.Create(ctx, meta)
+	}
+	// A materialized view can't outlive its base table.
+	names := make([]string, 0)
+	for _, table := range rollupTables {
+		for _, p := range RollupPeriods {
+			names = append(names, RollupTableName(table, p))
+		}
+	}
+	names = append(names, tableName, MetricsTableName, GroupedMetricsTableName, OrgMetricsTableName)
+	for _, name := range names {
+		ref := s.dataset.Table(name)
+		if _, err := ref.Metadata(ctx); err != nil {
+			continue
+		}
+		if err := ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	if _, ok := md.Labels[rollupsLabel]; !ok {
+		return nil
+	}
+	var update bigquery.DatasetMetadataToUpdate
+	update.DeleteLabel(rollupsLabel)
+	_, err = s.dataset.Update(ctx, update, "")
+	return err
+}
+
+// Flush implements Sink. The rollups are only created or changed when
+// their definitions differ from the version in the label rollupsLabel of
+// the dataset, so a regular run reads the metadata of the dataset and
+// nothing else.
+func (s *BigQuerySink) Flush(ctx context.Context) error {
+	md, err := s.dataset.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	version := rollupsVersion(s.dataset.ProjectID, s.dataset.DatasetID)
+	if md.Labels[rollupsLabel] == version {
+		return nil
+	}
+	if err := s.ensureRollups(ctx); err != nil {
+		return err
+	}
+	var update bigquery.DatasetMetadataToUpdate
+	update.SetLabel(rollupsLabel, version)
+	_, err = s.dataset.Update(ctx, update, md.ETag)
+	return err
+}
+
+// ensureTable creates the table if it doesn't exist, and adds the columns
+// of schema missing in an existing table, also within records such as
+// measurements, so that tables and columns added in a new version are
+// available before the next rebuild. See mergeSchema.
+func ensureTable(ctx context.Context, ref *bigquery.Table, schema bigquery.Schema) error {
+	if md, err := ref.Metadata(ctx); err == nil {
+		updated, changed := mergeSchema(md.Schema, schema)
+		if !changed {
+			return nil
+		}
+		_, err := ref.Update(ctx, bigquery.TableMetadataToUpdate{Schema: updated}, md.ETag)
+		return err
+	}
+	return ref.Create(ctx, &bigquery.TableMetadata{
+		Schema:         schema,
+		ExpirationTime: time.Now().AddDate(2, 0, 0), // Table will be automatically deleted in 2 years.
+	})
+}
+
+// mergeSchema returns existing with the fields of schema it lacks added,
+// recursing into records present in both, and whether anything was added.
+// BigQuery only adds nullable or repeated columns to a table, so added
+// fields which are required in schema are made nullable. existing is not
+// modified.
+func mergeSchema(existing bigquery.Schema, schema bigquery.Schema) (merged bigquery.Schema, changed bool) {
+	wanted := make(map[string]*bigquery.FieldSchema, len(schema))
+	for _, f := range schema {
+		wanted[f.Name] = f
+	}
+	found := make(map[string]bool, len(existing))
+	merged = make(bigquery.Schema, 0, len(existing)+len(schema))
+	for _, f := range existing {
+		found[f.Name] = true
+		if w, ok := wanted[f.Name]; ok && f.Type == bigquery.RecordFieldType && w.Type == bigquery.RecordFieldType {
+			if nested, ok := mergeSchema(f.Schema, w.Schema); ok {
+				tmp := *f
+				tmp.Schema = nested
+				f = &tmp
+				changed = true
+			}
+		}
+		merged = append(merged, f)
+	}
+	for _, f := range schema {
+		if found[f.Name] {
+			continue
+		}
+		tmp := *f
+		tmp.Required = false
+		merged = append(merged, &tmp)
+		changed = true
+	}
+	return merged, changed
+}
+
+// rollupTables are the tables with rollups.
+var rollupTables = []string{MetricsTableName, GroupedMetricsTableName, OrgMetricsTableName}
+
+// rollupsLabel is the label of the dataset with the version of the rollups
+// from rollupsVersion.
+const rollupsLabel = "idharvest-rollups"
+
+// rollupsVersion returns a hash of the queries of all rollups, which
+// changes with their definitions.
+func rollupsVersion(project string, dataset string) string {
+	h := fnv.New64a()
+	for _, table := range rollupTables {
+		for _, p := range RollupPeriods {
+			io.WriteString(h, RollupTableName(table, p))
+			io.WriteString(h, rollupQuery(project, dataset, table, p))
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// ensureRollups creates the materialized views of RollupPeriods over the
+// metrics, grouped metrics and organization tables, replacing tables, views
+// and materialized views of the same name with another query. BigQuery
+// keeps materialized views up to date as rows are added, and queries read
+// the stored sums rather than the hourly tables.
+func (s *BigQuerySink) ensureRollups(ctx context.Context) error {
+	for _, table := range rollupTables {
+		if err := ensureTable(ctx, s.dataset.Table(table), MetricSchema()); err != nil {
+			return err
+		}
+		for _, p := range RollupPeriods {
+			ref := s.dataset.Table(RollupTableName(table, p))
+			query := rollupQuery(s.dataset.ProjectID, s.dataset.DatasetID, table, p)
+			if md, err := ref.Metadata(ctx); err == nil {
+				if md.Type == bigquery.MaterializedView && md.MaterializedView != nil && md.MaterializedView.Query == query {
+					continue
+				}
+				// The query of a materialized view can't be changed.
+				if err := ref.Delete(ctx); err != nil {
+					return err
+				}
+			}
+			err := ref.Create(ctx, &bigquery.TableMetadata{
+				MaterializedView: &bigquery.MaterializedViewDefinition{
+					Query:           query,
+					EnableRefresh:   true,
+					RefreshInterval: time.Hour,
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollupQuery returns the query of the materialized view summing table by
+// p. Periods are Norwegian calendar periods, summed the same way as
+// ResampleMetricsIn with Oslo.
+func rollupQuery(project string, dataset string, table string, p Period) string {
+	parts := map[Period]string{
+		PeriodHour:    "HOUR",
+		PeriodDay:     "DAY",
+		PeriodWeek:    "ISOWEEK",
+		PeriodMonth:   "MONTH",
+		PeriodQuarter: "QUARTER",
+		PeriodYear:    "YEAR",
+	}
+	start := fmt.Sprintf(`TIMESTAMP_TRUNC(timestamp, %s, "%s")`, parts[p], TimeZone)
+	local := fmt.Sprintf(`DATETIME(%s, "%s")`, start, TimeZone)
+	return fmt.Sprintf(`SELECT
+	%[4]s AS timestamp,
+	metode,
+	SUM(antall) AS antall,
+	gruppering,
+	%[5]s AS lokaltid,
+	orgnr
+FROM `+"`%[1]s.%[2]s.%[3]s`"+`
+GROUP BY %[4]s, metode, gruppering, %[5]s, orgnr`, project, dataset, table, start, local)
+}
+
+// wait blocks until the limiter ticks or ctx is done.
+func wait(ctx context.Context, limiter <-chan time.Time) error {
+	select {
+	case <-limiter:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
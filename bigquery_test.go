@@ -0,0 +1,100 @@
+package idharvest
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// withoutField returns a copy of schema without the field name in the
+// record parent, like a table created before the field was added.
+func withoutField(schema bigquery.Schema, parent string, name string) bigquery.Schema {
+	old := make(bigquery.Schema, 0, len(schema))
+	for _, f := range schema {
+		if f.Name == parent {
+			tmp := *f
+			tmp.Schema = make(bigquery.Schema, 0, len(f.Schema))
+			for _, nested := range f.Schema {
+				if nested.Name != name {
+					tmp.Schema = append(tmp.Schema, nested)
+				}
+			}
+			f = &tmp
+		}
+		old = append(old, f)
+	}
+	return old
+}
+
+func TestMergeSchema(t *testing.T) {
+	schema, err := StatistikkSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := mergeSchema(schema, schema); changed {
+		t.Error("mergeSchema() should leave a current schema as it is")
+	}
+
+	// A nav table from before measurements.other and categories.other,
+	// and before the last known method.
+	last := Methods[len(Methods)-1].Column
+	old := withoutField(withoutField(withoutField(schema, "measurements", "other"), "categories", "other"), "measurements", last)
+	merged, changed := mergeSchema(old, schema)
+	if !changed {
+		t.Fatal("mergeSchema() should add the nested fields")
+	}
+	for _, parent := range []string{"measurements", "categories"} {
+		names := fieldNames(merged, parent)
+		if len(names) == 0 || names[len(names)-1] != "other" {
+			t.Error("mergeSchema() should add ", parent, ".other, got ", names)
+		}
+	}
+	var added *bigquery.FieldSchema
+	for _, f := range merged[1].Schema {
+		if f.Name == last {
+			added = f
+		}
+	}
+	if added == nil || added.Required {
+		t.Error("mergeSchema() should add ", last, " as nullable, got ", added)
+	}
+	if _, changed := mergeSchema(merged, schema); changed {
+		t.Error("mergeSchema() should be done after one update")
+	}
+	if len(fieldNames(old, "measurements")) != len(Methods)-1 {
+		t.Error("mergeSchema() modified the existing schema")
+	}
+}
+
+func fieldNames(schema bigquery.Schema, parent string) []string {
+	names := make([]string, 0)
+	for _, f := range schema {
+		if f.Name == parent {
+			for _, nested := range f.Schema {
+				names = append(names, nested.Name)
+			}
+		}
+	}
+	return names
+}
+
+func TestRollupQuery(t *testing.T) {
+	for _, p := range RollupPeriods {
+		q := rollupQuery("p", "d", MetricsTableName, p)
+		part := strings.ToUpper(p.String())
+		if p == PeriodWeek {
+			part = "ISOWEEK"
+		}
+		if !strings.Contains(q, "TIMESTAMP_TRUNC(timestamp, "+part+`, "Europe/Oslo")`) || !strings.Contains(q, "`p.d.navmetrics`") {
+			t.Error("Unexpected rollup query for ", p, ": ", q)
+		}
+		if strings.Contains(q, "CREATE") || strings.Contains(q, "GROUP BY 1") {
+			t.Error("The query of a materialized view should group by columns and expressions: ", q)
+		}
+	}
+	version := rollupsVersion("p", "d")
+	if version != rollupsVersion("p", "d") || version == rollupsVersion("p", "e") {
+		t.Error("The version should follow the queries, got ", version)
+	}
+}
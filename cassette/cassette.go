@@ -0,0 +1,182 @@
+// Package cassette records responses from the statistics API to files and
+// replays them, so that tests can run on real data without the network.
+//
+// Each response is stored in its own file in a directory, keyed by the path
+// and query of the request. The host is not part of the key, so responses
+// recorded from the real API replay against any base URL:
+//
+//	c := &idharvest.Client{
+//		HTTPClient: &http.Client{Transport: cassette.New("testdata/cassettes")},
+//		Retry:      idharvest.NoRetry,
+//	}
+//
+// By default the transport only replays. Set EnvRecord to "true" to fetch
+// and record the responses again.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvRecord makes New record instead of replay when set to "true":
+//
+//	IDHARVEST_RECORD=true go test ./...
+const EnvRecord = "IDHARVEST_RECORD"
+
+// Mode selects whether a Transport replays or records.
+type Mode int
+
+const (
+	// Replay answers from the recorded files and fails with
+	// ErrNotRecorded for requests without one.
+	Replay Mode = iota
+	// Record sends every request and records successful responses,
+	// replacing any earlier recording.
+	Record
+	// ReplayOrRecord replays recorded responses and records the rest.
+	ReplayOrRecord
+)
+
+// ErrNotRecorded is returned, wrapped, when replaying a request which was
+// never recorded.
+var ErrNotRecorded = errors.New("cassette: request not recorded")
+
+// Cassette is a recorded response, stored as JSON.
+type Cassette struct {
+	// URL is the key of the recording, the path and the query.
+	URL         string          `json:"url"`
+	StatusCode  int             `json:"statusCode"`
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// Transport is an http.RoundTripper which replays or records responses in
+// Dir.
+type Transport struct {
+	Dir  string
+	Mode Mode
+	// Next sends requests when recording, http.DefaultTransport if nil.
+	Next http.RoundTripper
+}
+
+// New returns a transport for dir which replays, or records if EnvRecord is
+// set.
+func New(dir string) *Transport {
+	t := &Transport{Dir: dir, Mode: Replay}
+	if os.Getenv(EnvRecord) == "true" {
+		t.Mode = Record
+	}
+	return t
+}
+
+// Key returns the key of a request for u: the path followed by the query
+// with its parameters sorted.
+func Key(u *url.URL) string {
+	key := u.EscapedPath()
+	if u.RawQuery != "" {
+		key += "?" + u.Query().Encode()
+	}
+	return key
+}
+
+// Path returns the file of the recording for u.
+func (t *Transport) Path(u *url.URL) string {
+	sum := sha256.Sum256([]byte(Key(u)))
+	return filepath.Join(t.Dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := t.Path(req.URL)
+	switch t.Mode {
+	case Record:
+		return t.record(req, path)
+	case ReplayOrRecord:
+		if _, err := os.Stat(path); err != nil {
+			return t.record(req, path)
+		}
+	}
+	return t.replay(req, path)
+}
+
+func (t *Transport) replay(req *http.Request, path string) (*http.Response, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrNotRecorded, Key(req.URL))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("cassette: %v: %v", path, err)
+	}
+	header := make(http.Header)
+	if c.ContentType != "" {
+		header.Set("Content-Type", c.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}, nil
+}
+
+// record sends req and stores the response if it is a success. Failures
+// are returned as they are, so that a flaky API isn't recorded.
+func (t *Transport) record(req *http.Request, path string) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("cassette: response to %v is not JSON", Key(req.URL))
+	}
+	c := Cassette{
+		URL:         Key(req.URL),
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        json.RawMessage(strings.TrimSpace(string(body))),
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
@@ -0,0 +1,78 @@
+package cassette
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	a, _ := url.Parse("https://statistikk-utdata.difi.no/991825827/idporten-innlogging/hours?to=2020-05-02T00:00:00Z&from=2020-05-01T00:00:00Z")
+	b, _ := url.Parse("http://127.0.0.1:1234/991825827/idporten-innlogging/hours?from=2020-05-01T00:00:00Z&to=2020-05-02T00:00:00Z")
+	if Key(a) != Key(b) {
+		t.Errorf("Key() should ignore the host and the order of parameters, got %v and %v", Key(a), Key(b))
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z"}]` + "\n"))
+	}))
+	defer srv.Close()
+
+	get := func(tr *Transport, path string) (int, string, error) {
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b), err
+	}
+
+	recorder := &Transport{Dir: dir, Mode: Record, Next: srv.Client().Transport}
+	if status, body, err := get(recorder, "/hours?from=a"); err != nil || status != 200 || body != `[{"timestamp":"2020-05-01T00:00:00Z"}]`+"\n" {
+		t.Fatal("Record should pass the response on, got ", status, body, err)
+	}
+	if status, _, _ := get(recorder, "/hours?fail=1"); status != 500 {
+		t.Error("Record should pass failures on, got ", status)
+	}
+
+	player := &Transport{Dir: dir}
+	if status, body, err := get(player, "/hours?from=a"); err != nil || status != 200 || body != `[{"timestamp":"2020-05-01T00:00:00Z"}]` {
+		t.Error("Replay, got ", status, body, err)
+	}
+	if _, _, err := get(player, "/hours?fail=1"); !errors.Is(err, ErrNotRecorded) {
+		t.Error("Failures should not be recorded, got ", err)
+	}
+	if calls != 2 {
+		t.Error("Replay should not send requests, got ", calls)
+	}
+
+	auto := &Transport{Dir: dir, Mode: ReplayOrRecord, Next: srv.Client().Transport}
+	get(auto, "/hours?from=a")
+	get(auto, "/hours?from=b")
+	if calls != 3 {
+		t.Error("ReplayOrRecord should only send requests not recorded, got ", calls)
+	}
+	if _, _, err := get(player, "/hours?from=b"); err != nil {
+		t.Error("ReplayOrRecord should record, got ", err)
+	}
+}
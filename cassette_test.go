@@ -0,0 +1,119 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tovare/idporten/cassette"
+)
+
+// cassetteClient replays the responses in testdata/cassettes, see
+// testdata/cassettes/README.md.
+func cassetteClient() *Client {
+	return &Client{
+		HTTPClient:   &http.Client{Transport: cassette.New("testdata/cassettes")},
+		Retry:        NoRetry,
+		WindowMonths: 1,
+	}
+}
+
+// TestCassetteMay2020 runs on a real response from the API, the same as in
+// TestUnmarshal.
+func TestCassetteMay2020(t *testing.T) {
+	may := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	stat, err := cassetteClient().Query(may, may.AddDate(0, 0, 1), OrgNr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != 25 {
+		t.Fatal("Expected 25 hours, got ", len(stat))
+	}
+	if m := stat[0].Measurements; m.BankIDMobil() != 188 || m.MinIDOTC() != 11 || m.BuyPass() != 2 || m.BankID() != 151 {
+		t.Error("Unexpected measurements in the first hour ", m)
+	}
+	for _, s := range Series(stat) {
+		// Antall is everything, including the federated logins.
+		if s.Measurements.Sum() != s.Measurements.Antall()-s.Measurements.Federated() {
+			t.Error("Sum should be Antall without Federated, got ", s)
+		}
+		sum := 0
+		for _, m := range s.ToMetrics() {
+			sum += m.Antall
+		}
+		if sum != s.Measurements.Sum() {
+			t.Error("Metrics should add up to the sum, got ", sum)
+		}
+	}
+	// Antall less Federated over the 24 hours of 1 May.
+	day := Series(stat).Resample(PeriodDay)
+	if len(day) != 2 || day[0].Sum != 194895-171323 {
+		t.Error("Resample(PeriodDay), got ", day)
+	}
+}
+
+// TestCassetteMonthBoundary harvests April 2020 and 1 May from both
+// organizations in windows of a month, so the month and the windows change
+// at midnight on 1 May, and checks the hours against the daily sums of the
+// API. It is skipped until the responses are recorded from the real API,
+// see testdata/cassettes/README.md.
+func TestCassetteMonthBoundary(t *testing.T) {
+	april := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 5, 1, 23, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	h := &Harvester{
+		Client: cassetteClient(),
+		Orgs:   []OrgPeriod{{Org: OrgNr, From: april}, {Org: OldOrg, From: april}},
+	}
+	orgs, perOrg, err := h.HarvestOrgs(ctx, april, to)
+	if errors.Is(err, cassette.ErrNotRecorded) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	hours := int(to.Sub(april)/time.Hour) + 1
+	orgTotal := 0
+	for i, s := range perOrg {
+		if len(s) != hours || len(s.Gaps(time.Hour)) != 0 {
+			t.Error("Expected every hour of ", orgs[i], ", got ", len(s))
+		}
+		for _, v := range s {
+			orgTotal += v.Measurements.Sum()
+		}
+	}
+	// The same hour as in TestCassetteMay2020.
+	if first := perOrg[0].Between(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC)); len(first) != 1 || first[0].Measurements.BankIDMobil() != 188 {
+		t.Error("The first hour of May should be the one recorded for 1 May, got ", first)
+	}
+
+	series := h.MergeSeries(orgs, perOrg)
+	total := 0
+	for _, v := range series {
+		total += v.Sum
+	}
+	if len(series) != hours || total != orgTotal {
+		t.Error("The merged series should sum the organizations, got ", len(series), total, orgTotal)
+	}
+
+	days, err := cassetteClient().Fetch(ctx, QueryOptions{
+		Granularity: SumDays,
+		From:        april,
+		To:          to,
+		Categories:  map[string]string{CategoryOrgnum: string(OrgNr)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resampled := perOrg[0].Resample(PeriodDay)
+	if len(days) != 31 || len(resampled) != len(days) {
+		t.Fatal("Expected the 31 days from 1 April to 1 May, got ", len(days), len(resampled))
+	}
+	for i, d := range days {
+		if !d.Timestamp.Equal(resampled[i].Timestamp) || d.Measurements.Sum() != resampled[i].Sum {
+			t.Errorf("Day %v of the API is %v, the hours sum to %v", d.Timestamp, d.Measurements.Sum(), resampled[i].Sum)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package idharvest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the ID-porten login statistics published by Digdir.
+const DefaultBaseURL = "https://statistikk-utdata.difi.no/991825827/idporten-innlogging"
+
+// DefaultUserAgent is sent with every request unless the client overrides it.
+const DefaultUserAgent = "idharvest (+https://github.com/tovare/idporten)"
+
+// Client reads from the statistics API. The zero value is ready to use and
+// talks to DefaultBaseURL with http.DefaultClient.
+//
+// Point BaseURL at a mirror, a proxy or an httptest.Server to harvest from
+// somewhere else:
+//
+//	c := &idharvest.Client{BaseURL: srv.URL, Timeout: 10 * time.Second}
+//	stat, err := c.Query(from, to, idharvest.OrgNr)
+type Client struct {
+	// BaseURL is the dataset root, without a trailing resource such as /hours.
+	BaseURL string
+	// HTTPClient is used for requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// UserAgent is sent in the User-Agent header, DefaultUserAgent if empty.
+	UserAgent string
+	// Timeout limits each request, including reading the body. Zero keeps
+	// the timeout of HTTPClient.
+	Timeout time.Duration
+	// Retry decides how failed requests are repeated, DefaultRetryPolicy if
+	// nil. Use NoRetry to fail on the first error.
+	Retry *RetryPolicy
+	// WindowMonths is the longest period QueryRange requests at a time,
+	// DefaultWindowMonths if zero.
+	WindowMonths int
+	// Workers is the number of requests QueryRange and QueryRanges run in
+	// parallel, one if zero.
+	Workers int
+	// Limiter, if set, is waited on before every request, retries included.
+	Limiter *RateLimiter
+	// StrictMethods makes queries fail with an *UnknownMethodError as soon
+	// as a measurement of an authentication method not known to this
+	// package is decoded. By default such methods are kept in
+	// Statistikk.Measurements.
+	StrictMethods bool
+}
+
+// Granularity selects the resource, and with it the time resolution, of a
+// query.
+type Granularity string
+
+const (
+	// Hours, Days, Months and Years return one value per period.
+	Hours  Granularity = "hours"
+	Days   Granularity = "days"
+	Months Granularity = "months"
+	Years  Granularity = "years"
+	// SumDays, SumMonths and SumYears return hourly values summed over each
+	// period.
+	SumDays   Granularity = "hours/sum/days"
+	SumMonths Granularity = "hours/sum/months"
+	SumYears  Granularity = "hours/sum/years"
+)
+
+// QueryOptions describes a query against the statistics API.
+type QueryOptions struct {
+	// Granularity selects the resource, Hours if empty.
+	Granularity Granularity
+	// From and To limit the period.
+	From time.Time
+	To   time.Time
+	// Categories filters on category values, for instance
+	// {CategoryOrgnum: string(OrgNr)}.
+	Categories map[string]string
+	// GroupBy breaks the result down by the named categories. The values
+	// are returned in Statistikk.Categories.
+	GroupBy []string
+}
+
+// DefaultClient is used by the package level Query functions. It reads four
+// windows in parallel while staying at two requests per second on average.
+var DefaultClient = &Client{
+	Workers: 4,
+	Limiter: NewRateLimiter(2, 4),
+}
+
+// Query reads hourly statistics for orgnum from the API and returns an array
+// of Statistikk.
+func (c *Client) Query(from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return c.QueryContext(context.Background(), from, to, orgnum)
+}
+
+// QueryContext is like Query but aborts the request when ctx is cancelled or
+// its deadline expires. Temporary failures are retried according to
+// c.Retry.
+func (c *Client) QueryContext(ctx context.Context, from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return c.QueryGranularity(ctx, Hours, from, to, orgnum)
+}
+
+// QueryGranularity is like QueryContext but reads the resource for g, so
+// that a monthly dashboard can ask for SumMonths instead of summing hourly
+// rows itself. Each Statistikk then covers one period starting at its
+// Timestamp.
+func (c *Client) QueryGranularity(ctx context.Context, g Granularity, from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return c.Fetch(ctx, QueryOptions{
+		Granularity: g,
+		From:        from,
+		To:          to,
+		Categories:  map[string]string{CategoryOrgnum: string(orgnum)},
+	})
+}
+
+// Fetch reads the statistics described by opts.
+func (c *Client) Fetch(ctx context.Context, opts QueryOptions) (stat []Statistikk, err error) {
+	queryURL := c.queryURL(opts)
+	err = c.retryPolicy().do(ctx, func() error {
+		// Start over on every attempt, a failed attempt may have
+		// decoded part of the response.
+		stat = make([]Statistikk, 0)
+		return c.get(ctx, queryURL, func(s Statistikk) error {
+			stat = append(stat, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// get makes a single attempt at reading queryURL, calling fn for each
+// Statistikk as it is decoded.
+func (c *Client) get(ctx context.Context, queryURL string, fn func(Statistikk) error) (err error) {
+
+	if c.Limiter != nil {
+		if err = c.Limiter.Wait(ctx); err != nil {
+			return
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, queryURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, excerptSize))
+		return &APIError{
+			StatusCode: res.StatusCode,
+			URL:        req.URL.String(),
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	if c.StrictMethods {
+		next := fn
+		fn = func(s Statistikk) error {
+			if unknown := FindUnknownMethods([]Statistikk{s}); len(unknown) > 0 {
+				return &UnknownMethodError{Methods: unknown}
+			}
+			return next(s)
+		}
+	}
+	return decodeEach(req.URL.String(), res.Body, fn)
+}
+
+func (c *Client) queryURL(opts QueryOptions) string {
+	g := opts.Granularity
+	if g == "" {
+		g = Hours
+	}
+	queryURL := c.baseURL() + "/" + string(g) +
+		"?from=" + DateToString(opts.From) + "&" +
+		"to=" + DateToString(opts.To)
+	if len(opts.Categories) > 0 {
+		names := make([]string, 0, len(opts.Categories))
+		for name := range opts.Categories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		filters := make([]string, 0, len(names))
+		for _, name := range names {
+			filters = append(filters, url.QueryEscape(name)+"="+url.QueryEscape(opts.Categories[name]))
+		}
+		queryURL += "&categories=" + strings.Join(filters, ",")
+	}
+	if len(opts.GroupBy) > 0 {
+		groups := make([]string, 0, len(opts.GroupBy))
+		for _, name := range opts.GroupBy {
+			groups = append(groups, url.QueryEscape(name))
+		}
+		queryURL += "&groupBy=" + strings.Join(groups, ",")
+	}
+	return queryURL
+}
+
+func (c *Client) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+func (c *Client) workers() int {
+	if c.Workers < 1 {
+		return 1
+	}
+	return c.Workers
+}
+
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return c.Retry
+}
+
+func (c *Client) userAgent() string {
+	if c.UserAgent == "" {
+		return DefaultUserAgent
+	}
+	return c.UserAgent
+}
+
+// httpClient returns the configured http.Client with Timeout applied. The
+// caller's client is copied rather than modified.
+func (c *Client) httpClient() *http.Client {
+	hc := http.DefaultClient
+	if c.HTTPClient != nil {
+		hc = c.HTTPClient
+	}
+	if c.Timeout > 0 {
+		tmp := *hc
+		tmp.Timeout = c.Timeout
+		hc = &tmp
+	}
+	return hc
+}
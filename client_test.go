@@ -0,0 +1,236 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClientQuery points the client at a local stand-in and checks the
+// request it sends.
+func TestClientQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/991825827/idporten-innlogging/hours" {
+			t.Error("Unexpected path: ", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("categories"); got != "TE-orgnum="+string(OrgNr) {
+			t.Error("Unexpected categories: ", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "test-agent" {
+			t.Error("Unexpected user agent: ", got)
+		}
+		w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1,"BankID":2},"categories":{"TE-orgnum":"889640782"}}]`))
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		BaseURL:   srv.URL + "/991825827/idporten-innlogging/",
+		UserAgent: "test-agent",
+		Timeout:   5 * time.Second,
+	}
+	stat, err := c.Query(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		OrgNr)
+	if err != nil {
+		t.Fatal("Failed to read data:", err)
+	}
+	if len(stat) != 1 {
+		t.Fatal("Expected one result, got ", len(stat))
+	}
+	if stat[0].Measurements.BankID() != 2 {
+		t.Error("Incorrect BankID: ", stat[0])
+	}
+}
+
+func TestClientDefaults(t *testing.T) {
+	hc := &http.Client{}
+	c := &Client{HTTPClient: hc, Timeout: time.Second}
+	if c.baseURL() != DefaultBaseURL {
+		t.Error("Incorrect default base URL: ", c.baseURL())
+	}
+	if c.userAgent() != DefaultUserAgent {
+		t.Error("Incorrect default user agent: ", c.userAgent())
+	}
+	if got := c.httpClient(); got == hc || got.Timeout != time.Second {
+		t.Error("Timeout should be applied to a copy of the http.Client")
+	}
+	if hc.Timeout != 0 {
+		t.Error("The caller's http.Client was modified")
+	}
+}
+
+// TestClientQueryContext checks that a hung endpoint is abandoned when the
+// context deadline expires.
+func TestClientQueryContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := &Client{BaseURL: srv.URL}
+	start := time.Now()
+	_, err := c.QueryContext(ctx, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		OrgNr)
+	if err == nil {
+		t.Fatal("Expected an error when the deadline expires")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected context.DeadlineExceeded, got ", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("The request was not cancelled in time")
+	}
+}
+
+func TestClientQueryErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		temporary bool
+		check     func(t *testing.T, err error)
+	}{
+		{
+			name:      "Server error",
+			status:    http.StatusInternalServerError,
+			body:      "<html>Maintenance</html>",
+			temporary: true,
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatal("Expected *APIError, got ", err)
+				}
+				if apiErr.StatusCode != 500 || apiErr.Body != "<html>Maintenance</html>" {
+					t.Error("Incorrect error: ", apiErr)
+				}
+			},
+		},
+		{
+			name:      "Bad request",
+			status:    http.StatusBadRequest,
+			body:      "bad from",
+			temporary: false,
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatal("Expected *APIError, got ", err)
+				}
+			},
+		},
+		{
+			name:      "Maintenance page with status OK",
+			status:    http.StatusOK,
+			body:      "<html>Maintenance</html>",
+			temporary: false,
+			check: func(t *testing.T, err error) {
+				var decErr *DecodeError
+				if !errors.As(err, &decErr) {
+					t.Fatal("Expected *DecodeError, got ", err)
+				}
+				if string(decErr.Payload) != "<html>Maintenance</html>" {
+					t.Error("Incorrect payload: ", string(decErr.Payload))
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			c := &Client{BaseURL: srv.URL, Retry: NoRetry}
+			stat, err := c.Query(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+				OrgNr)
+			if err == nil {
+				t.Fatal("Expected an error, got ", stat)
+			}
+			tt.check(t, err)
+			if got := IsTemporary(err); got != tt.temporary {
+				t.Errorf("IsTemporary() = %v, want %v", got, tt.temporary)
+			}
+		})
+	}
+}
+
+func TestClientQueryGranularity(t *testing.T) {
+	tests := []struct {
+		g    Granularity
+		path string
+	}{
+		{"", "/hours"},
+		{Hours, "/hours"},
+		{Days, "/days"},
+		{Months, "/months"},
+		{SumMonths, "/hours/sum/months"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.g), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.path {
+					t.Errorf("path = %v, want %v", r.URL.Path, tt.path)
+				}
+				w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1}}]`))
+			}))
+			defer srv.Close()
+			c := &Client{BaseURL: srv.URL}
+			stat, err := c.QueryGranularity(context.Background(), tt.g,
+				time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+				OrgNr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stat) != 1 {
+				t.Error("Expected one result, got ", len(stat))
+			}
+		})
+	}
+}
+
+func TestClientFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if got := q.Get("categories"); got != "TE-orgnum=889640782,TL-orgnum=974760673" {
+			t.Error("Unexpected categories: ", got)
+		}
+		if got := q.Get("groupBy"); got != "TL-orgnum" {
+			t.Error("Unexpected groupBy: ", got)
+		}
+		w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1},"categories":{"TE-orgnum":"889640782","TL-orgnum":"974760673"}}]`))
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL}
+	stat, err := c.Fetch(context.Background(), QueryOptions{
+		From: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		Categories: map[string]string{
+			"TL-orgnum":    "974760673",
+			CategoryOrgnum: string(OrgNr),
+		},
+		GroupBy: []string{"TL-orgnum"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != 1 {
+		t.Fatal("Expected one result, got ", len(stat))
+	}
+	if got := stat[0].Categories.Get("TL-orgnum"); got != "974760673" {
+		t.Error("Category value was dropped: ", stat[0].Categories)
+	}
+}
@@ -1,44 +1,64 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 
 	idharvest "github.com/tovare/idporten"
 )
 
 func main() {
+	merge := flag.String("merge", "sum", `How to merge the organizations: "sum", "separate" or "prefer:<orgnr>"`)
+	csvDir := flag.String("csv", "", "Write the history as CSV files to this directory instead of BigQuery")
+	excel := flag.Bool("excel", false, "Write CSV for a spreadsheet with Norwegian settings, see idharvest.ExcelCSV")
+	parquetDir := flag.String("parquet", "", "Write the history as yearly Parquet files to this directory instead of BigQuery")
+	flag.Parse()
 	fmt.Println("hello")
-	err := idharvest.SendEverythingToBigquery()
+
+	strategy, err := idharvest.ParseMergeStrategy(*merge)
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(2)
 	}
+	idharvest.DefaultHarvester.Merge = strategy
 
-	/*
-		a, _ := readSeries("https://statistikk-utdata.difi.no/991825827/idporten-innlogging/hours/sum/months?from=2013-05-01T00:00:00Z&to=2019-05-31T23:59:59Z&categories=TE-orgnum=889640782")
-		for i := range a {
-			fmt.Println(a[i].Timestamp)
-		}
-	*/
-}
-
-/*func readSeries(query string) (Statistikk, error) {
+	// Stop harvesting cleanly on Ctrl-C.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
 
-	var sumresult Statistikk
-
-	res, err := http.Get(query)
-	if err != nil {
-		return sumresult, err
+	var sink idharvest.Sink
+	switch {
+	case *csvDir != "":
+		opts := idharvest.CSVOptions{}
+		if *excel {
+			opts = idharvest.ExcelCSV
+		}
+		sink = idharvest.NewCSVSink(*csvDir, opts)
+	case *parquetDir != "":
+		sink = idharvest.NewParquetSink(*parquetDir)
 	}
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return sumresult, err
+	if sink != nil {
+		result, err := idharvest.DefaultHarvester.Rebuild(ctx, sink)
+		if result != nil {
+			result.Log(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
 	}
-	json.Unmarshal(body, &sumresult)
 
-	for _, v := range sumresult {
-		fmt.Println(v.Timestamp)
+	err = idharvest.SendEverythingToBigqueryContext(ctx)
+	if err != nil {
+		fmt.Println(err)
 	}
-
-	return sumresult, nil
 }
-*/
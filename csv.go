@@ -0,0 +1,260 @@
+package idharvest
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CSVOptions controls the format of the CSV writers.
+type CSVOptions struct {
+	// Comma is the field delimiter, ',' if zero.
+	Comma rune
+	// Language selects the language of the header.
+	Language Language
+	// TimeFormat is the layout of timestamps, time.RFC3339 if empty.
+	TimeFormat string
+	// Location is the time zone of timestamps, UTC if nil.
+	Location *time.Location
+	// BOM writes a UTF-8 byte order mark first, which Excel needs to read
+	// the file as UTF-8 rather than Windows-1252.
+	BOM bool
+	// UTC adds a column with the timestamp in UTC, as RFC 3339, after the
+	// timestamp in Location.
+	UTC bool
+}
+
+// ExcelCSV opens directly in a spreadsheet with Norwegian settings, which
+// expects semicolons and a byte order mark, with timestamps on the wall
+// clock in Oslo. The wall clock has no offset, so the two hours from 02:00
+// on the last Sunday of October look the same, and 02:00 is missing on the
+// last Sunday of March. The timestamps in UTC, in a column of their own,
+// tell them apart.
+var ExcelCSV = CSVOptions{
+	Comma:      ';',
+	Language:   Norwegian,
+	TimeFormat: "2006-01-02 15:04",
+	Location:   Oslo,
+	BOM:        true,
+	UTC:        true,
+}
+
+// writer returns a csv.Writer for w, after writing the byte order mark if
+// o asks for one.
+func (o CSVOptions) writer(w io.Writer) (*csv.Writer, error) {
+	if o.BOM {
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return nil, err
+		}
+	}
+	cw := csv.NewWriter(w)
+	if o.Comma != 0 {
+		cw.Comma = o.Comma
+	}
+	return cw, nil
+}
+
+func (o CSVOptions) time(t time.Time) string {
+	loc, layout := o.Location, o.TimeFormat
+	if loc == nil {
+		loc = time.UTC
+	}
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.In(loc).Format(layout)
+}
+
+// timeHeader returns the names of the timestamp columns.
+func (o CSVOptions) timeHeader() []string {
+	header := []string{o.header("Tidspunkt", "Timestamp")}
+	if o.UTC {
+		header = append(header, o.header("Tidspunkt (UTC)", "Timestamp (UTC)"))
+	}
+	return header
+}
+
+// times returns the values of the timestamp columns.
+func (o CSVOptions) times(t time.Time) []string {
+	times := []string{o.time(t)}
+	if o.UTC {
+		times = append(times, t.UTC().Format(time.RFC3339))
+	}
+	return times
+}
+
+// header returns the Norwegian or the English column name.
+func (o CSVOptions) header(no string, en string) string {
+	if o.Language == English {
+		return en
+	}
+	return no
+}
+
+// WriteStatistikkCSV writes stat in the wide format: the timestamps, the
+// organization number, one column for each method in Methods and for each
+// unknown method in stat, and the sum. The header has the labels of the
+// methods, and the keys of the API for the unknown methods.
+func WriteStatistikkCSV(w io.Writer, stat []Statistikk, opts CSVOptions) error {
+	unknown := make(map[string]bool)
+	for _, s := range stat {
+		for _, k := range s.Measurements.Unknown() {
+			unknown[k] = true
+		}
+	}
+	keys := make([]string, 0, len(Methods)+len(unknown))
+	for _, m := range Methods {
+		keys = append(keys, m.Key)
+	}
+	others := make([]string, 0, len(unknown))
+	for k := range unknown {
+		others = append(others, k)
+	}
+	sort.Strings(others)
+	keys = append(keys, others...)
+
+	cw, err := opts.writer(w)
+	if err != nil {
+		return err
+	}
+	header := append(opts.timeHeader(), opts.header("Organisasjonsnummer", "Organization number"))
+	for _, k := range keys {
+		label := k
+		if m, ok := LookupMethod(k); ok {
+			label = m.Metode.DisplayName(opts.Language)
+		}
+		header = append(header, label)
+	}
+	header = append(header, opts.header("Sum", "Sum"))
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, 0, len(header))
+	for _, s := range stat {
+		record = append(record[:0], opts.times(s.Timestamp)...)
+		record = append(record, s.Categories.TEOrgnum)
+		for _, k := range keys {
+			record = append(record, strconv.Itoa(s.Measurements[k]))
+		}
+		record = append(record, strconv.Itoa(s.Sum))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMetricsCSV writes metrics in the long format, one row for each
+// metric with the timestamps, the display name of the method or group, see
+// Metric.DisplayName, the logins, the grouping and the organization number.
+func WriteMetricsCSV(w io.Writer, metrics []Metric, opts CSVOptions) error {
+	cw, err := opts.writer(w)
+	if err != nil {
+		return err
+	}
+	header := append(opts.timeHeader(),
+		opts.header("Metode", "Method"),
+		opts.header("Antall", "Logins"),
+		opts.header("Gruppering", "Grouping"),
+		opts.header("Organisasjonsnummer", "Organization number"),
+	)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		record := append(opts.times(m.Timestamp),
+			m.DisplayName(opts.Language),
+			strconv.Itoa(m.Antall),
+			m.Gruppering,
+			string(m.Org),
+		)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// CSVSink writes the tables of a run to CSV files in Dir, named after the
+// tables, for instance nav.csv and navmetrics.csv. The rows are kept in
+// memory until Flush, which replaces the files, so it is meant for
+// Harvester.Rebuild. Like ParquetSink the files are written to temporary
+// files first and only renamed once all are written, so a failed run leaves
+// the earlier files as they were.
+type CSVSink struct {
+	*MemorySink
+	Dir     string
+	Options CSVOptions
+}
+
+// NewCSVSink returns a sink writing to dir with opts.
+func NewCSVSink(dir string, opts CSVOptions) *CSVSink {
+	return &CSVSink{MemorySink: NewMemorySink(), Dir: dir, Options: opts}
+}
+
+// Flush implements Sink by writing the files.
+func (s *CSVSink) Flush(ctx context.Context) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	tables := []string{tableName, MetricsTableName, GroupedMetricsTableName, OrgMetricsTableName}
+	tmp := make([]string, 0, len(tables))
+	defer func() {
+		// Only the files not renamed are left.
+		for _, path := range tmp {
+			os.Remove(path)
+		}
+	}()
+	for _, table := range tables {
+		write := func(w io.Writer) error {
+			return WriteMetricsCSV(w, s.Metrics(table), s.Options)
+		}
+		if table == tableName {
+			write = func(w io.Writer) error {
+				return WriteStatistikkCSV(w, s.Series(), s.Options)
+			}
+		}
+		path, err := s.writeFile(ctx, table, write)
+		if err != nil {
+			return err
+		}
+		tmp = append(tmp, path)
+	}
+	for i, table := range tables {
+		if err := os.Rename(tmp[i], filepath.Join(s.Dir, table+".csv")); err != nil {
+			return err
+		}
+	}
+	return s.MemorySink.Flush(ctx)
+}
+
+// writeFile writes table with write to a temporary file in Dir, unless ctx
+// is done, and returns its path.
+func (s *CSVSink) writeFile(ctx context.Context, table string, write func(io.Writer) error) (path string, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(s.Dir, "."+table+"-*.csv")
+	if err != nil {
+		return "", err
+	}
+	if err = f.Chmod(0644); err == nil {
+		err = write(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
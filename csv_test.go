@@ -0,0 +1,203 @@
+package idharvest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteStatistikkCSV(t *testing.T) {
+	s := hours(OrgNr, 3, 22)
+	s[0].Measurements["Nytt"] = 2
+	s[0].Sum = 5
+	var b bytes.Buffer
+	if err := WriteStatistikkCSV(&b, s, ExcelCSV); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b.Bytes(), []byte("\uFEFF")) {
+		t.Error("ExcelCSV should start with a byte order mark")
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b.Bytes(), []byte("\uFEFF"))))
+	r.Comma = ';'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal("WriteStatistikkCSV() should write a header and a row, got ", records)
+	}
+	header, row := records[0], records[1]
+	if len(header) != len(Methods)+5 || header[0] != "Tidspunkt" || header[1] != "Tidspunkt (UTC)" || header[len(header)-1] != "Sum" {
+		t.Error("The header should have the timestamps, the organization, the methods, the unknown method and the sum, got ", header)
+	}
+	if !strings.Contains(strings.Join(header, ";"), "BankID på mobil") {
+		t.Error("The header should have the Norwegian labels, got ", header)
+	}
+	// 22 UTC is midnight in Oslo in summer.
+	if row[0] != "2020-05-02 00:00" || row[1] != "2020-05-01T22:00:00Z" || row[2] != string(OrgNr) || row[len(row)-1] != "5" || row[len(row)-2] != "2" {
+		t.Error("Unexpected row ", row)
+	}
+	for i, name := range header {
+		if name == methodFor(KeyMinID).Label && row[i] != "3" {
+			t.Error("MinID should be 3, got ", row[i])
+		}
+	}
+}
+
+func TestWriteStatistikkCSVUnknownMethods(t *testing.T) {
+	s := hours(OrgNr, 1, 0)
+	s[0].Measurements["Foo ID"] = 2
+	s[0].Measurements["Foo-ID"] = 4
+	var b bytes.Buffer
+	if err := WriteStatistikkCSV(&b, s, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, row := records[0], records[1]
+	n := len(header)
+	if header[n-3] != "Foo ID" || header[n-2] != "Foo-ID" || row[n-3] != "2" || row[n-2] != "4" {
+		t.Error("Unknown methods should have a column each named by the API, got ", header, row)
+	}
+}
+
+func TestWriteCSVEndOfSummerTime(t *testing.T) {
+	if Oslo == time.UTC {
+		t.Skip("No time zone database")
+	}
+	// 00:00 and 01:00 UTC on 25 October 2020 are both 02:00 in Oslo.
+	metrics := []Metric{
+		{Timestamp: time.Date(2020, 10, 25, 0, 0, 0, 0, time.UTC), Metode: MinID, Antall: 1},
+		{Timestamp: time.Date(2020, 10, 25, 1, 0, 0, 0, time.UTC), Metode: MinID, Antall: 2},
+	}
+	var b bytes.Buffer
+	if err := WriteMetricsCSV(&b, metrics, ExcelCSV); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b.Bytes(), []byte("\uFEFF"))))
+	r.Comma = ';'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[1][0] != "2020-10-25 02:00" || records[2][0] != records[1][0] || records[1][1] == records[2][1] {
+		t.Error("The rows should have the same local time and different UTC times, got ", records)
+	}
+}
+
+func TestWriteMetricsCSV(t *testing.T) {
+	metrics := []Metric{
+		{Timestamp: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC), Metode: BankIDMobil, Antall: 7, Org: OrgNr},
+		{Timestamp: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC), Metode: Metode(LevelHigh), Antall: 7, Gruppering: ByAssuranceLevel.Name},
+	}
+	var b bytes.Buffer
+	if err := WriteMetricsCSV(&b, metrics, CSVOptions{Language: English}); err != nil {
+		t.Fatal(err)
+	}
+	want := "Timestamp,Method,Logins,Grouping,Organization number\n" +
+		"2020-05-01T12:00:00Z,BankID on mobile,7,," + string(OrgNr) + "\n" +
+		"2020-05-01T12:00:00Z,High,7,sikkerhetsniva,\n"
+	if b.String() != want {
+		t.Errorf("WriteMetricsCSV() got\n%v\nwant\n%v", b.String(), want)
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	ts := httptest.NewServer(orgHandler(t, map[Org]int{OrgNr: 1}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "idharvest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	h := &Harvester{
+		Client: &Client{BaseURL: ts.URL, HTTPClient: ts.Client(), Retry: NoRetry},
+		Orgs:   []OrgPeriod{{Org: OrgNr, From: now.Add(-24 * time.Hour)}},
+	}
+	sink := NewCSVSink(dir, CSVOptions{})
+	result, err := h.Rebuild(context.Background(), sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string]int{
+		tableName:               result.Rows,
+		MetricsTableName:        result.Metrics,
+		GroupedMetricsTableName: result.GroupedMetrics,
+		OrgMetricsTableName:     result.OrgMetrics,
+	}
+	for table, n := range rows {
+		f, err := os.Open(filepath.Join(dir, table+".csv"))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil || len(records) != n+1 {
+			t.Error(table, ".csv should have a header and ", n, " rows, got ", len(records), err)
+		}
+	}
+}
+
+// cancelAfter is a context which is cancelled after n calls to Err.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestCSVSinkKeepsFilesOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idharvest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	sink := NewCSVSink(dir, CSVOptions{})
+	if err := sink.WriteSeries(ctx, hours(OrgNr, 1, 0, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, tableName+".csv")
+	before, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run cancelled after nav.csv is written.
+	if err := sink.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteSeries(ctx, hours(OrgNr, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(&cancelAfter{Context: ctx, n: 1}); err != context.Canceled {
+		t.Error("Flush() with a cancelled context should fail, got ", err)
+	}
+	if after, err := ioutil.ReadFile(file); err != nil || !bytes.Equal(after, before) {
+		t.Error("A failed Flush() should keep the earlier files, got ", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, ".*")); len(tmp) != 0 {
+		t.Error("A failed Flush() should remove its temporary files, got ", tmp)
+	}
+}
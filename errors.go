@@ -0,0 +1,70 @@
+package idharvest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// excerptSize limits how much of a failed response is kept in errors.
+const excerptSize = 512
+
+// APIError is returned when the statistics API answers with a status code
+// outside 2xx, for instance a 500 or an HTML maintenance page.
+type APIError struct {
+	StatusCode int    // HTTP status code of the response.
+	URL        string // The requested URL.
+	Body       string // The start of the response body.
+
+	// RetryAfter is the wait requested in a Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("idharvest: %s from %s: %q", http.StatusText(e.StatusCode), e.URL, e.Body)
+}
+
+// Temporary reports whether the request may succeed if repeated later, that
+// is for 429 Too Many Requests and 5xx server errors.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// DecodeError is returned when a response can not be parsed as an array of
+// Statistikk.
+type DecodeError struct {
+	URL     string // The requested URL.
+	Payload []byte // The start of the offending response body.
+	Err     error  // The error from encoding/json.
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("idharvest: decoding response from %s: %v: %q", e.URL, e.Err, e.Payload)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether err is a failure worth retrying later. Decode
+// errors and 4xx responses are permanent, the same request will fail again.
+func IsTemporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) {
+		return temp.Temporary()
+	}
+	return false
+}
+
+// excerpt returns at most excerptSize bytes of b.
+func excerpt(b []byte) []byte {
+	if len(b) > excerptSize {
+		return b[:excerptSize]
+	}
+	return b
+}
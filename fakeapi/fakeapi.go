@@ -0,0 +1,403 @@
+// Package fakeapi is a stand-in for the ID-porten statistics API at
+// statistikk-utdata.difi.no, for development and offline tests.
+//
+// The server answers the hourly resource and the aggregated resources with
+// data for the configured organizations, checks the query parameters, and
+// can be told to fail in the ways the real API does:
+//
+//	srv := fakeapi.New(map[string]fakeapi.Org{
+//		"889640782": {From: time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)},
+//	})
+//	defer srv.Close()
+//	srv.Inject(fakeapi.ServerError, fakeapi.Truncated)
+//	c := &idharvest.Client{BaseURL: srv.URL, HTTPClient: srv.Client()}
+//
+// The package doesn't depend on idharvest, so that its tests can use it.
+package fakeapi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is the path of the dataset, as in the real API.
+const Path = "/991825827/idporten-innlogging"
+
+// CategoryOrgnum is the category holding the organization number.
+const CategoryOrgnum = "TE-orgnum"
+
+// Keys are the measurement keys served by Synthetic, as named by the API.
+var Keys = []string{
+	"MinID passport", "Commfides", "Buypass passport", "eIDAS", "MinID",
+	"BankID mobil", "MinID OTC", "BuyPass", "MinID PIN", "BankID",
+}
+
+// DataFunc returns the measurements of the hour starting at ts.
+type DataFunc func(ts time.Time) map[string]int
+
+// Org configures the data served for an organization number.
+type Org struct {
+	// From and To limit the hours with data. A zero To means no end.
+	From time.Time
+	To   time.Time
+	// Data returns the measurements of an hour, Synthetic for the
+	// organization number if nil.
+	Data DataFunc
+}
+
+// Fault is an error returned instead of a regular response.
+type Fault int
+
+const (
+	// ServerError answers 500 Internal Server Error.
+	ServerError Fault = iota + 1
+	// TooManyRequests answers 429 Too Many Requests with Retry-After: 0.
+	TooManyRequests
+	// Slow answers normally after Server.Delay.
+	Slow
+	// Malformed answers 200 with a body which isn't JSON.
+	Malformed
+	// Truncated answers 200 and closes the connection halfway through the
+	// body.
+	Truncated
+)
+
+func (f Fault) String() string {
+	switch f {
+	case 0:
+		return "none"
+	case ServerError:
+		return "server error"
+	case TooManyRequests:
+		return "too many requests"
+	case Slow:
+		return "slow"
+	case Malformed:
+		return "malformed"
+	case Truncated:
+		return "truncated"
+	}
+	return "fault " + strconv.Itoa(int(f))
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Resource   string
+	From       time.Time
+	To         time.Time
+	Categories string
+	GroupBy    string
+	Fault      Fault
+}
+
+// Server is a running fake API. URL includes Path, so it can be used as the
+// base URL of a client.
+type Server struct {
+	URL string
+	// Delay is the wait of a Slow response.
+	Delay time.Duration
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	orgs     map[string]Org
+	faults   []Fault
+	requests []Request
+	errs     []error
+}
+
+// New starts a server serving orgs.
+func New(orgs map[string]Org) *Server {
+	s := &Server{orgs: orgs, Delay: time.Second}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL + Path
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an HTTP client for the server.
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// Inject queues faults, which are returned by the next requests in order.
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Errors returns the problems with the requests received so far, such as a
+// missing from parameter or an unknown resource. Such requests are answered
+// with 400 Bad Request or 404 Not Found.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// resources maps the resources to the period they sum over.
+var resources = map[string]func(time.Time) time.Time{
+	"hours":            truncateHour,
+	"days":             truncateDay,
+	"months":           truncateMonth,
+	"years":            truncateYear,
+	"hours/sum/days":   truncateDay,
+	"hours/sum/months": truncateMonth,
+	"hours/sum/years":  truncateYear,
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := s.parse(r)
+	s.mu.Lock()
+	if err == nil && len(s.faults) > 0 {
+		req.Fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	s.requests = append(s.requests, req)
+	if err != nil {
+		s.errs = append(s.errs, err)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		status := http.StatusBadRequest
+		if _, ok := resources[req.Resource]; !ok {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch req.Fault {
+	case ServerError:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	case TooManyRequests:
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	case Malformed:
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1}},<html>`)
+		return
+	case Slow:
+		select {
+		case <-time.After(s.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body, err := json.Marshal(s.values(req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if req.Fault == Truncated {
+		// The server closes the connection when the handler returns
+		// before Content-Length bytes are written.
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body[:len(body)/2])
+		return
+	}
+	w.Write(body)
+}
+
+// parse checks the request against what the real API accepts.
+func (s *Server) parse(r *http.Request) (req Request, err error) {
+	if !strings.HasPrefix(r.URL.Path, Path+"/") {
+		return req, fmt.Errorf("fakeapi: unknown path %v", r.URL.Path)
+	}
+	req.Resource = strings.TrimPrefix(r.URL.Path, Path+"/")
+	if _, ok := resources[req.Resource]; !ok {
+		return req, fmt.Errorf("fakeapi: unknown resource %v", req.Resource)
+	}
+	if r.Method != http.MethodGet {
+		return req, fmt.Errorf("fakeapi: method %v not allowed", r.Method)
+	}
+	q := r.URL.Query()
+	for name := range q {
+		switch name {
+		case "from", "to", "categories", "groupBy":
+		default:
+			return req, fmt.Errorf("fakeapi: unknown parameter %v", name)
+		}
+	}
+	if req.From, err = parseTime(q, "from"); err != nil {
+		return req, err
+	}
+	if req.To, err = parseTime(q, "to"); err != nil {
+		return req, err
+	}
+	if req.To.Before(req.From) {
+		return req, fmt.Errorf("fakeapi: to %v before from %v", req.To, req.From)
+	}
+	req.Categories = q.Get("categories")
+	if req.Categories != "" {
+		org := strings.TrimPrefix(req.Categories, CategoryOrgnum+"=")
+		if org == req.Categories || strings.Contains(org, ",") {
+			return req, fmt.Errorf("fakeapi: unsupported categories %q", req.Categories)
+		}
+		if _, ok := s.orgs[org]; !ok {
+			return req, fmt.Errorf("fakeapi: unknown organization %v", org)
+		}
+	}
+	req.GroupBy = q.Get("groupBy")
+	if req.GroupBy != "" && req.GroupBy != CategoryOrgnum {
+		return req, fmt.Errorf("fakeapi: unsupported groupBy %q", req.GroupBy)
+	}
+	return req, nil
+}
+
+func parseTime(q map[string][]string, name string) (time.Time, error) {
+	v, ok := q[name]
+	if !ok || len(v) != 1 {
+		return time.Time{}, fmt.Errorf("fakeapi: missing parameter %v", name)
+	}
+	t, err := time.Parse(time.RFC3339, v[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("fakeapi: invalid %v: %v", name, err)
+	}
+	return t.UTC(), nil
+}
+
+// value is a Statistikk in the JSON format of the API.
+type value struct {
+	Timestamp    time.Time         `json:"timestamp"`
+	Measurements map[string]int    `json:"measurements"`
+	Categories   map[string]string `json:"categories"`
+}
+
+// values returns the response to req: the hours starting from and
+// including From to and including To, summed by the period of the resource.
+func (s *Server) values(req Request) []value {
+	first := req.From.Truncate(time.Hour)
+	if first.Before(req.From) {
+		first = first.Add(time.Hour)
+	}
+	orgs := make([]string, 0, len(s.orgs))
+	for org := range s.orgs {
+		if req.Categories == "" || req.Categories == CategoryOrgnum+"="+org {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Strings(orgs)
+
+	truncate := resources[req.Resource]
+	index := make(map[string]int)
+	values := make([]value, 0)
+	for _, org := range orgs {
+		o := s.orgs[org]
+		data := o.Data
+		if data == nil {
+			data = Synthetic(org)
+		}
+		for ts := first; !ts.After(req.To); ts = ts.Add(time.Hour) {
+			if ts.Before(o.From) || (!o.To.IsZero() && ts.After(o.To)) {
+				continue
+			}
+			start := truncate(ts)
+			k := start.Format(time.RFC3339)
+			categories := map[string]string{}
+			if req.Categories != "" || req.GroupBy != "" {
+				categories[CategoryOrgnum] = org
+				k += org
+			}
+			i, ok := index[k]
+			if !ok {
+				i = len(values)
+				index[k] = i
+				values = append(values, value{Timestamp: start, Measurements: map[string]int{}, Categories: categories})
+			}
+			for key, n := range data(ts) {
+				values[i].Measurements[key] += n
+			}
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Timestamp.Before(values[j].Timestamp)
+	})
+	return values
+}
+
+// Synthetic returns deterministic data for org, with more logins in the
+// daytime. The measurements include "Federated" and "Antall", the total of
+// the other methods and Federated, like the real API.
+func Synthetic(org string) DataFunc {
+	weights := []int{0, 0, 0, 1, 2, 60, 5, 2, 1, 50}
+	return func(ts time.Time) map[string]int {
+		h := fnv.New64a()
+		io.WriteString(h, org)
+		binary.Write(h, binary.BigEndian, ts.Unix())
+		rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+
+		activity := 1
+		if hour := ts.UTC().Hour(); hour >= 6 && hour < 21 {
+			activity = 10
+		}
+		m := make(map[string]int, len(Keys)+2)
+		sum := 0
+		for i, k := range Keys {
+			n := weights[i]*activity + rnd.Intn(weights[i]+1)
+			m[k] = n
+			sum += n
+		}
+		m["Federated"] = sum * 8
+		m["Antall"] = sum + m["Federated"]
+		return m
+	}
+}
+
+// LoadData reads values in the JSON format of the hourly resource, for
+// instance a response saved from the real API, and returns their
+// measurements. Hours not in r have no measurements.
+func LoadData(r io.Reader) (DataFunc, error) {
+	var values []value
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return nil, err
+	}
+	data := make(map[int64]map[string]int, len(values))
+	for _, v := range values {
+		data[v.Timestamp.Unix()] = v.Measurements
+	}
+	return func(ts time.Time) map[string]int {
+		return data[ts.Unix()]
+	}, nil
+}
+
+func truncateHour(t time.Time) time.Time {
+	return t.Truncate(time.Hour)
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func truncateMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func truncateYear(t time.Time) time.Time {
+	return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+}
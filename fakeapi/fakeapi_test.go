@@ -0,0 +1,165 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var may = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func get(t *testing.T, srv *Server, path string) (*http.Response, []value) {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil
+	}
+	var values []value
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, &values); err != nil {
+			return resp, nil
+		}
+	}
+	return resp, values
+}
+
+func TestHours(t *testing.T) {
+	srv := New(map[string]Org{
+		"1": {From: may},
+		"2": {From: may.Add(2 * time.Hour), To: may.Add(3 * time.Hour)},
+	})
+	defer srv.Close()
+
+	_, values := get(t, srv, "/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&categories=TE-orgnum=1")
+	if len(values) != 6 {
+		t.Fatal("Expected 6 hours including to, got ", len(values))
+	}
+	for _, v := range values {
+		if v.Categories[CategoryOrgnum] != "1" {
+			t.Error("Missing organization number in ", v)
+		}
+		if !reflect.DeepEqual(v.Measurements, Synthetic("1")(v.Timestamp)) {
+			t.Error("Measurements should be Synthetic, got ", v)
+		}
+	}
+	_, again := get(t, srv, "/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&categories=TE-orgnum=1")
+	if !reflect.DeepEqual(values, again) {
+		t.Error("Data should be deterministic")
+	}
+
+	if _, values := get(t, srv, "/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&categories=TE-orgnum=2"); len(values) != 2 {
+		t.Error("Expected the 2 hours organization 2 was in use, got ", values)
+	}
+	if _, values := get(t, srv, "/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&groupBy=TE-orgnum"); len(values) != 8 {
+		t.Error("Expected 8 hours grouped by organization, got ", len(values))
+	}
+	_, total := get(t, srv, "/hours?from=2020-05-01T02:00:00Z&to=2020-05-01T02:00:00Z")
+	want := Synthetic("1")(may.Add(2 * time.Hour))["Antall"] + Synthetic("2")(may.Add(2 * time.Hour))["Antall"]
+	if len(total) != 1 || total[0].Measurements["Antall"] != want || len(total[0].Categories) != 0 {
+		t.Error("Without categories the organizations should be summed, got ", total)
+	}
+}
+
+func TestAggregated(t *testing.T) {
+	srv := New(map[string]Org{"1": {From: may}})
+	defer srv.Close()
+	_, days := get(t, srv, "/hours/sum/days?from=2020-05-01T00:00:00Z&to=2020-05-03T00:00:00Z&categories=TE-orgnum=1")
+	if len(days) != 3 {
+		t.Fatal("Expected 3 days, got ", len(days))
+	}
+	sum := 0
+	for h := 0; h < 24; h++ {
+		sum += Synthetic("1")(may.Add(time.Duration(h) * time.Hour))["BankID"]
+	}
+	if days[0].Measurements["BankID"] != sum || !days[1].Timestamp.Equal(may.AddDate(0, 0, 1)) {
+		t.Error("Days should sum the hours, got ", days[0])
+	}
+	if _, months := get(t, srv, "/months?from=2020-05-01T00:00:00Z&to=2020-07-01T00:00:00Z"); len(months) != 3 {
+		t.Error("Expected 3 months, got ", months)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	srv := New(map[string]Org{"1": {From: may}})
+	defer srv.Close()
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/minutes?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z", http.StatusNotFound},
+		{"/hours?to=2020-05-01T05:00:00Z", http.StatusBadRequest},
+		{"/hours?from=2020-05-01&to=2020-05-01T05:00:00Z", http.StatusBadRequest},
+		{"/hours?from=2020-05-02T00:00:00Z&to=2020-05-01T05:00:00Z", http.StatusBadRequest},
+		{"/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&categories=TE-orgnum=3", http.StatusBadRequest},
+		{"/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&categories=1", http.StatusBadRequest},
+		{"/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z&orgnum=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp, _ := get(t, srv, tt.path); resp.StatusCode != tt.status {
+			t.Errorf("GET %v = %v, want %v", tt.path, resp.StatusCode, tt.status)
+		}
+	}
+	if len(srv.Errors()) != len(tests) || len(srv.Requests()) != len(tests) {
+		t.Error("Every bad request should be recorded, got ", srv.Errors())
+	}
+}
+
+func TestFaults(t *testing.T) {
+	srv := New(map[string]Org{"1": {From: may}})
+	defer srv.Close()
+	srv.Delay = 10 * time.Millisecond
+	srv.Inject(ServerError, TooManyRequests, Malformed, Truncated, Slow)
+	path := "/hours?from=2020-05-01T00:00:00Z&to=2020-05-01T05:00:00Z"
+
+	if resp, _ := get(t, srv, path); resp.StatusCode != http.StatusInternalServerError {
+		t.Error("ServerError, got ", resp.Status)
+	}
+	if resp, _ := get(t, srv, path); resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "0" {
+		t.Error("TooManyRequests, got ", resp.Status)
+	}
+	if resp, values := get(t, srv, path); resp.StatusCode != http.StatusOK || values != nil {
+		t.Error("Malformed should not decode, got ", values)
+	}
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err == nil || !strings.Contains(err.Error(), "EOF") {
+		t.Error("Truncated should fail while reading the body, got ", err)
+	}
+	resp.Body.Close()
+	start := time.Now()
+	if _, values := get(t, srv, path); len(values) != 6 || time.Since(start) < srv.Delay {
+		t.Error("Slow should answer after the delay, got ", values)
+	}
+	if _, values := get(t, srv, path); len(values) != 6 {
+		t.Error("The faults should be used up, got ", values)
+	}
+
+	faults := make([]Fault, 0)
+	for _, r := range srv.Requests() {
+		faults = append(faults, r.Fault)
+	}
+	if want := []Fault{ServerError, TooManyRequests, Malformed, Truncated, Slow, 0}; !reflect.DeepEqual(faults, want) {
+		t.Errorf("Requests() faults = %v, want %v", faults, want)
+	}
+}
+
+func TestLoadData(t *testing.T) {
+	data, err := LoadData(strings.NewReader(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"BankID":151},"categories":{"TE-orgnum":"889640782"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data(may)["BankID"] != 151 || data(may.Add(time.Hour)) != nil {
+		t.Error("LoadData() should return the recorded hours only")
+	}
+}
@@ -0,0 +1,292 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tovare/idporten/fakeapi"
+)
+
+var fastRetry = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func newFakeAPI(from time.Time) (*fakeapi.Server, *Client) {
+	srv := fakeapi.New(map[string]fakeapi.Org{
+		string(OrgNr):  {From: from},
+		string(OldOrg): {From: from, To: from.Add(24 * time.Hour)},
+	})
+	return srv, &Client{BaseURL: srv.URL, HTTPClient: srv.Client(), Retry: fastRetry}
+}
+
+func TestFakeAPIQuery(t *testing.T) {
+	may := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	srv, c := newFakeAPI(may)
+	defer srv.Close()
+
+	stat, err := c.Query(may, may.AddDate(0, 0, 1), OrgNr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != 25 {
+		t.Fatal("Expected 25 hours, got ", len(stat))
+	}
+	for _, s := range stat {
+		if len(s.Measurements.Unknown()) != 0 || s.Categories.TEOrgnum != string(OrgNr) {
+			t.Error("Unexpected value ", s)
+		}
+		if s.Measurements.Sum()+s.Measurements[KeyFederated] != s.Measurements[KeyAntall] {
+			t.Error("Antall should be the sum and Federated, got ", s)
+		}
+	}
+
+	months, err := c.QueryGranularity(context.Background(), SumMonths, may, may.AddDate(0, 2, 0), OrgNr)
+	if err != nil || len(months) != 3 {
+		t.Error("QueryGranularity(SumMonths), got ", len(months), err)
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
+
+func TestFakeAPIFaults(t *testing.T) {
+	may := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	srv, c := newFakeAPI(may)
+	defer srv.Close()
+	ctx := context.Background()
+	opts := QueryOptions{From: may, To: may.Add(5 * time.Hour), Categories: map[string]string{CategoryOrgnum: string(OrgNr)}}
+
+	srv.Inject(fakeapi.ServerError, fakeapi.Truncated)
+	if stat, err := c.Fetch(ctx, opts); err != nil || len(stat) != 6 {
+		t.Error("Fetch() should recover from temporary faults, got ", len(stat), err)
+	}
+
+	srv.Inject(fakeapi.TooManyRequests, fakeapi.TooManyRequests, fakeapi.TooManyRequests)
+	var apiErr *APIError
+	if _, err := c.Fetch(ctx, opts); !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Error("Fetch() should give up after MaxAttempts, got ", err)
+	}
+
+	srv.Inject(fakeapi.Malformed)
+	var decodeErr *DecodeError
+	if _, err := c.Fetch(ctx, opts); !errors.As(err, &decodeErr) {
+		t.Error("Fetch() should fail on malformed JSON, got ", err)
+	}
+
+	srv.Delay = time.Second
+	srv.Inject(fakeapi.Slow)
+	slow := *c
+	slow.Timeout = 50 * time.Millisecond
+	slow.Retry = NoRetry
+	if _, err := slow.Fetch(ctx, opts); err == nil {
+		t.Error("Fetch() should time out on a slow response")
+	}
+
+	if len(srv.Requests()) != 8 {
+		t.Error("Expected 8 requests, got ", len(srv.Requests()))
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
+
+func TestFakeAPIRebuild(t *testing.T) {
+	from := time.Now().UTC().Truncate(time.Hour).Add(-72 * time.Hour)
+	srv, c := newFakeAPI(from)
+	defer srv.Close()
+	c.WindowMonths = 1
+	h := &Harvester{
+		Client: c,
+		Orgs: []OrgPeriod{
+			{Org: OrgNr, From: from},
+			{Org: OldOrg, From: from, To: from.Add(24 * time.Hour)},
+		},
+		Now: func() time.Time { return from.Add(72*time.Hour + 30*time.Minute) },
+	}
+	ctx := context.Background()
+	sink := NewMemorySink()
+	srv.Inject(fakeapi.ServerError)
+	if _, err := h.Rebuild(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	series := sink.Series()
+	if len(series) != 73 || len(series.Gaps(time.Hour)) != 0 {
+		t.Fatal("Rebuild() should harvest 73 hours without gaps, got ", len(series))
+	}
+	if len(sink.Metrics(OrgMetricsTableName)) != (len(series)+25)*len(series[0].ToMetrics()) {
+		t.Error("Expected a breakdown including 25 hours of OldOrg, got ", len(sink.Metrics(OrgMetricsTableName)))
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
+
+func TestFakeAPIQueryRangeEachGrouped(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 3, 0)
+	srv := fakeapi.New(map[string]fakeapi.Org{
+		string(OrgNr):  {From: from},
+		string(OldOrg): {From: from},
+	})
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL, HTTPClient: srv.Client(), Retry: fastRetry, WindowMonths: 1}
+
+	rows := make(map[Org]int)
+	err := c.QueryRangeEach(context.Background(), QueryOptions{From: from, To: to, GroupBy: []string{CategoryOrgnum}}, func(s Statistikk) error {
+		rows[Org(s.Categories.TEOrgnum)]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hours := int(to.Sub(from)/time.Hour) + 1
+	if rows[OrgNr] != hours || rows[OldOrg] != hours {
+		t.Error("Every organization should have ", hours, " hours once, got ", rows)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Error("Expected 3 requests, got ", n)
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
+
+// TestFakeAPIQueryRangeGranularities checks that splitting a period into
+// windows gives the same values as a single call, with periods of every
+// granularity on the window boundaries.
+func TestFakeAPIQueryRangeGranularities(t *testing.T) {
+	from := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 2, 3, 5, 0, 0, 0, time.UTC)
+	srv, c := newFakeAPI(from)
+	defer srv.Close()
+	ctx := context.Background()
+	granularities := []Granularity{Hours, Days, Months, Years, SumDays, SumMonths, SumYears}
+	for _, g := range granularities {
+		t.Run(string(g), func(t *testing.T) {
+			opts := QueryOptions{Granularity: g, From: from, To: to, Categories: map[string]string{CategoryOrgnum: string(OrgNr)}}
+			want, err := c.Fetch(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.QueryRange(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("QueryRange() returned %v values, Fetch() %v", len(got), len(want))
+				for i := range got {
+					if i < len(want) && !reflect.DeepEqual(got[i], want[i]) {
+						t.Fatal("First difference ", got[i], want[i])
+					}
+				}
+			}
+			streamed := make([]Statistikk, 0)
+			err = c.QueryRangeEach(ctx, opts, func(s Statistikk) error {
+				streamed = append(streamed, s)
+				return nil
+			})
+			if err != nil || !reflect.DeepEqual(streamed, want) {
+				t.Error("QueryRangeEach() should return the values of Fetch(), got ", len(streamed), err)
+			}
+		})
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
+
+// TestFakeAPIHarvestMonths harvests two months of OrgNr and one of OldOrg
+// and checks the merge, the groupings and the Norwegian months against the
+// hours of each organization.
+func TestFakeAPIHarvestMonths(t *testing.T) {
+	march := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	may := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	srv := fakeapi.New(map[string]fakeapi.Org{
+		string(OrgNr):  {From: march},
+		string(OldOrg): {From: april},
+	})
+	defer srv.Close()
+	h := &Harvester{
+		Client: &Client{BaseURL: srv.URL, HTTPClient: srv.Client(), Retry: NoRetry, WindowMonths: 1},
+		Orgs: []OrgPeriod{
+			{Org: OrgNr, From: march},
+			{Org: OldOrg, From: april},
+		},
+	}
+	orgs, perOrg, err := h.HarvestOrgs(context.Background(), time.Time{}, may)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every hour of March and April, and the hour at To.
+	if len(perOrg[0]) != 61*24+1 || len(perOrg[1]) != 30*24+1 {
+		t.Fatal("Unexpected number of hours ", len(perOrg[0]), len(perOrg[1]))
+	}
+	orgTotals := make([]int, len(perOrg))
+	for i, s := range perOrg {
+		for _, v := range s {
+			orgTotals[i] += v.Measurements.Sum()
+		}
+	}
+
+	series := h.MergeSeries(orgs, perOrg)
+	total := 0
+	for _, v := range series {
+		total += v.Sum
+	}
+	if len(series) != len(perOrg[0]) || total != orgTotals[0]+orgTotals[1] {
+		t.Error("The merged series should sum the organizations, got ", len(series), total)
+	}
+	families := 0
+	for _, m := range GroupMetrics(series.Metrics(), ByFamily) {
+		families += m.Antall
+	}
+	if families != total {
+		t.Error("The families should add up to the sum, got ", families, total)
+	}
+
+	if Oslo == time.UTC {
+		t.Skip("No time zone database")
+	}
+	// Norwegian months of each organization. The first hours of April and
+	// May in Oslo are the last hours of March and April in UTC.
+	months := Breakdown(orgs, perOrg).ResampleIn(PeriodMonth, Oslo)
+	type month struct {
+		start time.Time
+		org   Org
+	}
+	want := []month{
+		{time.Date(2020, 3, 1, 0, 0, 0, 0, Oslo), OrgNr},
+		{time.Date(2020, 4, 1, 0, 0, 0, 0, Oslo), OrgNr},
+		{time.Date(2020, 4, 1, 0, 0, 0, 0, Oslo), OldOrg},
+		{time.Date(2020, 5, 1, 0, 0, 0, 0, Oslo), OrgNr},
+		{time.Date(2020, 5, 1, 0, 0, 0, 0, Oslo), OldOrg},
+	}
+	if len(months) != len(want) {
+		t.Fatal("Unexpected months ", months)
+	}
+	monthTotals := make(map[Org]int)
+	for i, w := range want {
+		m := months[i]
+		if !m.Timestamp.Equal(w.start) || Org(m.Categories.TEOrgnum) != w.org {
+			t.Errorf("Month %v = %v %v, want %v", i, m.Timestamp, m.Categories.TEOrgnum, w)
+		}
+		monthTotals[Org(m.Categories.TEOrgnum)] += m.Sum
+	}
+	if monthTotals[OrgNr] != orgTotals[0] || monthTotals[OldOrg] != orgTotals[1] {
+		t.Error("The months should add up to the hours, got ", monthTotals, orgTotals)
+	}
+	// May in Oslo starts at 22:00 UTC on 30 April, three hours before To.
+	mayHours := perOrg[0].Between(may.Add(-2*time.Hour), may.Add(time.Hour))
+	mayTotal := 0
+	for _, v := range mayHours {
+		mayTotal += v.Measurements.Sum()
+	}
+	if len(mayHours) != 3 || months[3].Sum != mayTotal {
+		t.Error("May in Oslo should be the last three hours, got ", months[3].Sum, mayTotal)
+	}
+	for _, err := range srv.Errors() {
+		t.Error(err)
+	}
+}
@@ -2,4 +2,9 @@ module github.com/tovare/idporten
 
 go 1.13
 
-require cloud.google.com/go/bigquery v1.13.0 
+require (
+	cloud.google.com/go v0.71.0
+	cloud.google.com/go/bigquery v1.13.0
+	github.com/xitongsys/parquet-go v1.6.1
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+)
@@ -0,0 +1,161 @@
+package idharvest
+
+import (
+	"sort"
+)
+
+// The provider families of the registered methods.
+const (
+	FamilyMinID     = "MinID"
+	FamilyBuypass   = "Buypass"
+	FamilyBankID    = "BankID"
+	FamilyCommfides = "Commfides"
+	FamilyEIDAS     = "EIDAS"
+)
+
+// AssuranceLevel is an eIDAS level of assurance.
+type AssuranceLevel string
+
+const (
+	// LevelSubstantial is "betydelig", the old security level 3.
+	LevelSubstantial AssuranceLevel = "substantial"
+	// LevelHigh is "høy", the old security level 4.
+	LevelHigh AssuranceLevel = "high"
+	// LevelUnknown is used for foreign eIDs through eIDAS, which may be on
+	// either level, and for methods unknown to this package.
+	LevelUnknown AssuranceLevel = "unknown"
+)
+
+// DisplayName returns a human readable name of l.
+func (l AssuranceLevel) DisplayName(lang Language) string {
+	labels := map[AssuranceLevel][2]string{
+		LevelSubstantial: {"Betydelig", "Substantial"},
+		LevelHigh:        {"Høy", "High"},
+		LevelUnknown:     {"Ukjent", "Unknown"},
+	}
+	v, ok := labels[l]
+	if !ok {
+		return string(l)
+	}
+	if lang == English {
+		return v[1]
+	}
+	return v[0]
+}
+
+// Grouping rolls methods up into groups, for instance all MinID variants
+// into MinID.
+type Grouping struct {
+	// Name identifies the grouping in Metric.Gruppering.
+	Name string
+	// Group returns the group of a method.
+	Group func(Method) string
+	// Label returns the display name of a group, the group itself if nil.
+	Label func(group string, lang Language) string
+}
+
+var (
+	// ByFamily groups methods by provider family.
+	ByFamily = Grouping{
+		Name:  "familie",
+		Group: func(m Method) string { return m.Family },
+	}
+	// ByAssuranceLevel groups methods by eIDAS level of assurance.
+	ByAssuranceLevel = Grouping{
+		Name:  "sikkerhetsniva",
+		Group: func(m Method) string { return string(m.Level) },
+		Label: func(group string, lang Language) string {
+			return AssuranceLevel(group).DisplayName(lang)
+		},
+	}
+)
+
+// Groupings are the groupings written to BigQuery.
+var Groupings = []Grouping{ByFamily, ByAssuranceLevel}
+
+// LookupGrouping returns the grouping in Groupings named name.
+func LookupGrouping(name string) (Grouping, bool) {
+	for _, g := range Groupings {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Grouping{}, false
+}
+
+// Group returns the logins of each group of g. Like ToMetrics only methods
+// with InMetrics are included, so the groups add up to Sum.
+func (m Measurements) Group(g Grouping) map[string]int {
+	groups := make(map[string]int)
+	for k, v := range m {
+		method := methodFor(k)
+		if !method.InMetrics {
+			continue
+		}
+		groups[g.Group(method)] += v
+	}
+	return groups
+}
+
+// ToGroupedMetrics returns one Metric for each group of each grouping, with
+// the group in Metode and the name of the grouping in Gruppering, see
+// Metric. The metrics are sorted by grouping and group.
+func (s Statistikk) ToGroupedMetrics(groupings ...Grouping) (metrics []Metric) {
+	metrics = make([]Metric, 0)
+	for _, g := range groupings {
+		groups := s.Measurements.Group(g)
+		names := make([]string, 0, len(groups))
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			metrics = append(metrics, Metric{
+				Timestamp:  s.Timestamp,
+				Metode:     Metode(name),
+				Antall:     groups[name],
+				Gruppering: g.Name,
+				Lokaltid:   LocalTime(s.Timestamp),
+			})
+		}
+	}
+	return
+}
+
+// GroupMetrics rolls raw metrics, as returned by ToMetrics, up into the
+// groups of g. Metrics for the same timestamp and group are summed, the
+// result is sorted by timestamp and group.
+func GroupMetrics(metrics []Metric, g Grouping) []Metric {
+	type key struct {
+		unix  int64
+		group string
+	}
+	sums := make(map[key]*Metric)
+	for _, m := range metrics {
+		if m.Gruppering != "" {
+			continue
+		}
+		method, ok := m.Metode.Method()
+		if !ok {
+			method = methodFor(string(m.Metode))
+		}
+		k := key{m.Timestamp.Unix(), g.Group(method)}
+		sum, ok := sums[k]
+		if !ok {
+			sum = &Metric{Timestamp: m.Timestamp, Metode: Metode(k.group), Gruppering: g.Name, Lokaltid: LocalTime(m.Timestamp)}
+			sums[k] = sum
+		}
+		sum.Antall += m.Antall
+	}
+	grouped := make([]Metric, 0, len(sums))
+	for _, m := range sums {
+		grouped = append(grouped, *m)
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		if !grouped[i].Timestamp.Equal(grouped[j].Timestamp) {
+			return grouped[i].Timestamp.Before(grouped[j].Timestamp)
+		}
+		return grouped[i].Metode < grouped[j].Metode
+	})
+	return grouped
+}
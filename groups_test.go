@@ -0,0 +1,86 @@
+package idharvest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	m := Measurements{
+		KeyMinID:       1,
+		KeyMinIDOTC:    2,
+		KeyMinIDPIN:    4,
+		KeyBankID:      8,
+		KeyBankIDMobil: 16,
+		KeyBuyPass:     32,
+		KeyEIDAS:       64,
+		KeyAntall:      1000,
+		KeyFederated:   1000,
+		"Foo ID":       128,
+	}
+	wantFamily := map[string]int{
+		FamilyMinID:     7,
+		FamilyBankID:    24,
+		FamilyBuypass:   32,
+		FamilyEIDAS:     64,
+		"FooID3c2bb410": 128,
+	}
+	if got := m.Group(ByFamily); !reflect.DeepEqual(got, wantFamily) {
+		t.Errorf("Group(ByFamily) = %v, want %v", got, wantFamily)
+	}
+	wantLevel := map[string]int{
+		string(LevelSubstantial): 7,
+		string(LevelHigh):        56,
+		string(LevelUnknown):     192,
+	}
+	if got := m.Group(ByAssuranceLevel); !reflect.DeepEqual(got, wantLevel) {
+		t.Errorf("Group(ByAssuranceLevel) = %v, want %v", got, wantLevel)
+	}
+}
+
+func TestToGroupedMetrics(t *testing.T) {
+	ts := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	s := Statistikk{Timestamp: ts, Measurements: Measurements{KeyMinID: 1, KeyMinIDPIN: 2, KeyBankID: 3}}
+	local := LocalTime(ts)
+	want := []Metric{
+		{Timestamp: ts, Metode: "BankID", Antall: 3, Gruppering: "familie", Lokaltid: local},
+		{Timestamp: ts, Metode: "MinID", Antall: 3, Gruppering: "familie", Lokaltid: local},
+		{Timestamp: ts, Metode: "high", Antall: 3, Gruppering: "sikkerhetsniva", Lokaltid: local},
+		{Timestamp: ts, Metode: "substantial", Antall: 3, Gruppering: "sikkerhetsniva", Lokaltid: local},
+	}
+	if got := s.ToGroupedMetrics(Groupings...); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToGroupedMetrics() = %v, want %v", got, want)
+	}
+
+	// Grouping the raw metrics gives the same result.
+	grouped := append(GroupMetrics(s.ToMetrics(), ByFamily), GroupMetrics(s.ToMetrics(), ByAssuranceLevel)...)
+	nonZero := make([]Metric, 0)
+	for _, m := range grouped {
+		if m.Antall != 0 {
+			nonZero = append(nonZero, m)
+		}
+	}
+	if !reflect.DeepEqual(nonZero, want) {
+		t.Errorf("GroupMetrics() = %v, want %v", nonZero, want)
+	}
+}
+
+func TestMetricDisplayName(t *testing.T) {
+	tests := []struct {
+		m    Metric
+		lang Language
+		want string
+	}{
+		{Metric{Metode: BankIDMobil}, Norwegian, "BankID på mobil"},
+		{Metric{Metode: Metode(LevelHigh), Gruppering: ByAssuranceLevel.Name}, Norwegian, "Høy"},
+		{Metric{Metode: Metode(LevelSubstantial), Gruppering: ByAssuranceLevel.Name}, English, "Substantial"},
+		{Metric{Metode: FamilyBankID, Gruppering: ByFamily.Name}, English, "BankID"},
+		{Metric{Metode: "Høy nivå", Gruppering: "egen"}, English, "Høy nivå"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.DisplayName(tt.lang); got != tt.want {
+			t.Errorf("DisplayName(%v) of %v = %q, want %q", tt.lang, tt.m, got, tt.want)
+		}
+	}
+}
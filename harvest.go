@@ -0,0 +1,219 @@
+package idharvest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OrgPeriod is an organization number and the period it was used to log in
+// to NAV. A zero To means it is still in use. Like the API, the period
+// includes the hour at To.
+type OrgPeriod struct {
+	Org  Org
+	From time.Time
+	To   time.Time
+}
+
+// DefaultOrgs are the organization numbers of NAV. OldOrg was in use in
+// parallel with OrgNr for a while, the period is padded at both ends.
+var DefaultOrgs = []OrgPeriod{
+	{Org: OrgNr, From: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{Org: OldOrg, From: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+// MergeStrategy combines the series of several organizations into one.
+type MergeStrategy struct {
+	// Name identifies the strategy, see ParseMergeStrategy.
+	Name string
+	// Merge combines the series, series[i] belonging to orgs[i]. The
+	// series are sorted and without duplicates.
+	Merge func(orgs []Org, series []Series) Series
+}
+
+var (
+	// MergeSum sums the measurements of all organizations for each
+	// timestamp, see Series.Merge. Categories.TEOrgnum of a sum is the
+	// first organization, the primary one, also where others are included,
+	// so that it stays a valid organization number and the same for every
+	// hour. The breakdown by organization is in OrgMetricsTableName.
+	MergeSum = MergeStrategy{
+		Name: "sum",
+		Merge: func(orgs []Org, series []Series) Series {
+			merged := make(Series, 0)
+			for _, s := range series {
+				merged = merged.Merge(s)
+			}
+			if len(orgs) > 0 {
+				for i := range merged {
+					merged[i].Categories.TEOrgnum = string(orgs[0])
+				}
+			}
+			return merged
+		},
+	}
+	// MergeSeparate keeps the values of each organization, with the
+	// organization number in Categories.TEOrgnum and Sum recalculated.
+	MergeSeparate = MergeStrategy{
+		Name: "separate",
+		Merge: func(orgs []Org, series []Series) Series {
+			merged := make(Series, 0)
+			for i, s := range series {
+				for _, v := range s {
+					v.Categories.TEOrgnum = string(orgs[i])
+					merged = append(merged, v.CalcSum())
+				}
+			}
+			merged.Sort()
+			return merged
+		},
+	}
+)
+
+// PreferOrg returns a strategy which uses the values of org, and values of
+// the other organizations only for timestamps where org has none. Among the
+// others the first in orgs wins.
+func PreferOrg(org Org) MergeStrategy {
+	return MergeStrategy{
+		Name: "prefer:" + string(org),
+		Merge: func(orgs []Org, series []Series) Series {
+			ordered := make([]Series, 0, len(series))
+			for i, s := range series {
+				if orgs[i] == org {
+					ordered = append([]Series{s}, ordered...)
+				} else {
+					ordered = append(ordered, s)
+				}
+			}
+			seen := make(map[int64]bool)
+			merged := make(Series, 0)
+			for _, s := range ordered {
+				for _, v := range s {
+					if seen[v.Timestamp.Unix()] {
+						continue
+					}
+					seen[v.Timestamp.Unix()] = true
+					merged = append(merged, v.CalcSum())
+				}
+			}
+			merged.Sort()
+			return merged
+		},
+	}
+}
+
+// ParseMergeStrategy returns the strategy named s: "sum", "separate" or
+// "prefer:" followed by an organization number.
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	switch {
+	case s == MergeSum.Name:
+		return MergeSum, nil
+	case s == MergeSeparate.Name:
+		return MergeSeparate, nil
+	case strings.HasPrefix(s, "prefer:") && len(s) > len("prefer:"):
+		return PreferOrg(Org(strings.TrimPrefix(s, "prefer:"))), nil
+	}
+	return MergeStrategy{}, fmt.Errorf("idharvest: unknown merge strategy %q", s)
+}
+
+// Harvester reads the series of several organizations and merges them. It is
+// used both by the rebuild and by the incremental stream. The zero value
+// harvests DefaultOrgs with DefaultClient and MergeSum.
+type Harvester struct {
+	Client *Client
+	Orgs   []OrgPeriod
+	Merge  MergeStrategy
+	// Now returns the current time, the end of a run, time.Now if nil.
+	Now func() time.Time
+}
+
+// DefaultHarvester is used by StreamLatestDataToBigQuery and
+// SendEverythingToBigquery.
+var DefaultHarvester = &Harvester{}
+
+// Harvest reads every organization from from until to, limited to the
+// period each one was in use, and merges the series. A zero from starts at
+// the beginning of each period.
+func (h *Harvester) Harvest(ctx context.Context, from time.Time, to time.Time) (Series, error) {
+	orgs, series, err := h.HarvestOrgs(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return h.MergeSeries(orgs, series), nil
+}
+
+// HarvestOrgs is like Harvest but returns the series of each organization
+// without merging them, series[i] belonging to orgs[i]. Organizations not in
+// use from from until to are left out.
+func (h *Harvester) HarvestOrgs(ctx context.Context, from time.Time, to time.Time) (orgs []Org, series []Series, err error) {
+	orgs = make([]Org, 0, len(h.orgs()))
+	queries := make([]QueryOptions, 0, len(h.orgs()))
+	for _, o := range h.orgs() {
+		start, end := o.From, to
+		if from.After(start) {
+			start = from
+		}
+		if !o.To.IsZero() && o.To.Before(end) {
+			end = o.To
+		}
+		if !start.Before(end) {
+			continue
+		}
+		orgs = append(orgs, o.Org)
+		queries = append(queries, QueryOptions{
+			From:       start,
+			To:         end,
+			Categories: map[string]string{CategoryOrgnum: string(o.Org)},
+		})
+	}
+	results, err := h.client().QueryRanges(ctx, queries...)
+	if err != nil {
+		return nil, nil, err
+	}
+	series = make([]Series, 0, len(results))
+	for _, r := range results {
+		series = append(series, Series(r))
+	}
+	return orgs, series, nil
+}
+
+// MergeSeries merges the series of HarvestOrgs with the strategy of h.
+func (h *Harvester) MergeSeries(orgs []Org, series []Series) Series {
+	return h.merge().Merge(orgs, series)
+}
+
+// Breakdown returns the values of each organization in series, with the
+// organization number in Categories.TEOrgnum, whatever strategy is used for
+// the merged series. See Series.OrgMetrics.
+func Breakdown(orgs []Org, series []Series) Series {
+	return MergeSeparate.Merge(orgs, series)
+}
+
+func (h *Harvester) client() *Client {
+	if h.Client == nil {
+		return DefaultClient
+	}
+	return h.Client
+}
+
+func (h *Harvester) orgs() []OrgPeriod {
+	if h.Orgs == nil {
+		return DefaultOrgs
+	}
+	return h.Orgs
+}
+
+func (h *Harvester) now() time.Time {
+	if h.Now == nil {
+		return time.Now()
+	}
+	return h.Now()
+}
+
+func (h *Harvester) merge() MergeStrategy {
+	if h.Merge.Merge == nil {
+		return MergeSum
+	}
+	return h.Merge
+}
@@ -0,0 +1,150 @@
+package idharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orgHandler answers like hourlyHandler, with MinID set to n[org] for the
+// organization in the categories parameter.
+func orgHandler(t *testing.T, n map[Org]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		org := Org(strings.TrimPrefix(r.URL.Query().Get("categories"), CategoryOrgnum+"="))
+		from := StringToDate(r.URL.Query().Get("from"))
+		to := StringToDate(r.URL.Query().Get("to"))
+		stat := make([]Statistikk, 0)
+		for ts := from; !ts.After(to); ts = ts.Add(time.Hour) {
+			var s Statistikk
+			s.Timestamp = ts
+			s.Measurements = Measurements{KeyMinID: n[org]}
+			s.Categories.TEOrgnum = string(org)
+			stat = append(stat, s)
+		}
+		if err := json.NewEncoder(w).Encode(stat); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	orgs := []Org{OrgNr, OldOrg}
+	series := []Series{hours(OrgNr, 1, 0, 1, 2), hours(OldOrg, 10, 1, 2, 3)}
+	tests := []struct {
+		strategy MergeStrategy
+		hours    []int
+		sums     []int
+		orgs     []string
+	}{
+		{MergeSum, []int{0, 1, 2, 3}, []int{1, 11, 11, 10}, []string{"889640782", "889640782", "889640782", "889640782"}},
+		{MergeSeparate, []int{0, 1, 1, 2, 2, 3}, []int{1, 1, 10, 1, 10, 10}, []string{"889640782", "889640782", "990983291", "889640782", "990983291", "990983291"}},
+		{PreferOrg(OldOrg), []int{0, 1, 2, 3}, []int{1, 10, 10, 10}, []string{"889640782", "990983291", "990983291", "990983291"}},
+		{PreferOrg(OrgNr), []int{0, 1, 2, 3}, []int{1, 1, 1, 10}, []string{"889640782", "889640782", "889640782", "990983291"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy.Name, func(t *testing.T) {
+			got := tt.strategy.Merge(orgs, series)
+			if !reflect.DeepEqual(timestamps(got), tt.hours) {
+				t.Fatalf("Merge() = %v, want %v", timestamps(got), tt.hours)
+			}
+			for i, v := range got {
+				if v.Sum != tt.sums[i] || v.Categories.TEOrgnum != tt.orgs[i] {
+					t.Errorf("Merge()[%v] = %v %v, want %v %v", i, v.Sum, v.Categories.TEOrgnum, tt.sums[i], tt.orgs[i])
+				}
+			}
+		})
+	}
+	if series[1][0].Sum != 0 {
+		t.Error("Merge() changed its input")
+	}
+	// The hours before, during and after the overlap are one day.
+	if days := MergeSum.Merge(orgs, series).Resample(PeriodDay); len(days) != 1 || days[0].Sum != 33 {
+		t.Error("A day of MergeSum should be a single bucket, got ", days)
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	for _, s := range []string{"sum", "separate", "prefer:990983291"} {
+		got, err := ParseMergeStrategy(s)
+		if err != nil || got.Name != s {
+			t.Errorf("ParseMergeStrategy(%q) = %v, %v", s, got.Name, err)
+		}
+	}
+	for _, s := range []string{"", "prefer:", "max"} {
+		if _, err := ParseMergeStrategy(s); err == nil {
+			t.Errorf("ParseMergeStrategy(%q) should fail", s)
+		}
+	}
+}
+
+func TestHarvest(t *testing.T) {
+	var mu sync.Mutex
+	queried := make(map[string]bool)
+	handler := orgHandler(t, map[Org]int{OrgNr: 1, OldOrg: 10})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queried[r.URL.Query().Get("categories")] = true
+		mu.Unlock()
+		handler(w, r)
+	}))
+	defer ts.Close()
+
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	h := &Harvester{
+		Client: &Client{BaseURL: ts.URL, HTTPClient: ts.Client(), Retry: NoRetry},
+		Orgs: []OrgPeriod{
+			{Org: OrgNr, From: start},
+			{Org: OldOrg, From: start.Add(2 * time.Hour), To: start.Add(4 * time.Hour)},
+		},
+	}
+	got, err := h.Harvest(context.Background(), time.Time{}, start.Add(6*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(timestamps(got), want) {
+		t.Fatalf("Harvest() = %v, want %v", timestamps(got), want)
+	}
+	for i, want := range []int{1, 1, 11, 11, 11, 1, 1} {
+		if got[i].Sum != want {
+			t.Errorf("Harvest()[%v].Sum = %v, want %v", i, got[i].Sum, want)
+		}
+	}
+
+	// Like the stream, starting after OldOrg went out of use.
+	queried = make(map[string]bool)
+	h.Merge = MergeSeparate
+	got, err = h.Harvest(context.Background(), start.Add(5*time.Hour), start.Add(6*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || queried[CategoryOrgnum+"="+string(OldOrg)] {
+		t.Error("Harvest() should only query organizations in use, got ", got)
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	orgs := []Org{OrgNr, OldOrg}
+	series := []Series{hours(OrgNr, 1, 0, 1), hours(OldOrg, 10, 1)}
+	metrics := Breakdown(orgs, series).OrgMetrics()
+	byOrg := make(map[Org]int)
+	for _, m := range metrics {
+		if m.Org == "" {
+			t.Fatal("OrgMetrics() should set Org, got ", m)
+		}
+		byOrg[m.Org] += m.Antall
+	}
+	if want := map[Org]int{OrgNr: 2, OldOrg: 10}; !reflect.DeepEqual(byOrg, want) {
+		t.Errorf("OrgMetrics() by organization = %v, want %v", byOrg, want)
+	}
+	for _, m := range MergeSum.Merge(orgs, series).Metrics() {
+		if m.Org != "" {
+			t.Error("Metrics() should not set Org, got ", m)
+		}
+	}
+}
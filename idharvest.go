@@ -23,15 +23,9 @@ package idharvest
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"sort"
+	"os"
 	"time"
-
-	"cloud.google.com/go/bigquery"
 )
 
 // Converts  a timestamp to the format 2014-05-01T20:00:00Z.
@@ -59,24 +53,20 @@ var OrgNr Org = "889640782" // Fra 2013 - d.d.
 // OldOrg was used  april 2018 - mai 2020
 var OldOrg Org = "990983291"
 
-// Query reads from the API and returns an array of Statistikk.
+// Query reads from the API and returns an array of Statistikk. It is a
+// shorthand for DefaultClient.Query.
 func Query(from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return DefaultClient.Query(from, to, orgnum)
+}
 
-	stat = make([]Statistikk, 0)
-	queryURL := "https://statistikk-utdata.difi.no/991825827/idporten-innlogging/hours" +
-		"?from=" + DateToString(from) + "&" +
-		"to=" + DateToString(to) + "&" +
-		"categories=TE-orgnum=" + string(orgnum)
-	res, err := http.Get(queryURL)
-	if err != nil {
-		return
-	}
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return
-	}
-	json.Unmarshal(body, &stat)
-	return
+// QueryContext is a shorthand for DefaultClient.QueryContext.
+func QueryContext(ctx context.Context, from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return DefaultClient.QueryContext(ctx, from, to, orgnum)
+}
+
+// QueryGranularity is a shorthand for DefaultClient.QueryGranularity.
+func QueryGranularity(ctx context.Context, g Granularity, from time.Time, to time.Time, orgnum Org) (stat []Statistikk, err error) {
+	return DefaultClient.QueryGranularity(ctx, g, from, to, orgnum)
 }
 
 const (
@@ -84,8 +74,23 @@ const (
 	tableName        string = "nav"
 	projectID        string = "homepage-961"
 	MetricsTableName string = "navmetrics"
+	// GroupedMetricsTableName holds the metrics rolled up by Groupings.
+	GroupedMetricsTableName string = "navgroups"
+	// OrgMetricsTableName holds the metrics of each organization, see
+	// Breakdown.
+	OrgMetricsTableName string = "navorgs"
 )
 
+// RollupPeriods are the periods with materialized views of the metrics
+// tables summed by period, see RollupTableName.
+var RollupPeriods = []Period{PeriodDay, PeriodWeek, PeriodMonth, PeriodQuarter, PeriodYear}
+
+// RollupTableName returns the name of the materialized view with the metrics of table
+// summed by p, for instance navmetrics_month.
+func RollupTableName(table string, p Period) string {
+	return table + "_" + p.String()
+}
+
 // PubSubMessage is the payload of a Pub/Sub event.
 type PubSubMessage struct {
 	Data []byte `json:"data"`
@@ -96,66 +101,26 @@ type PubSubMessage struct {
 // from idporten.
 //
 // Checks to see the most recent entry in BigQuery. Makes a query for the most
-// recent data and streams it into BigQuery.
+// recent data and streams it into BigQuery. The result of the run is logged
+// as a structured entry, with severity WARNING if the API returned
+// authentication methods this package doesn't know. If EnvFailOnUnknownMethod
+// is set such a run fails instead.
 //
 //
 //    gcloud functions deploy StreamLatestDataToBigQuery --memory=128 --runtime go113 --trigger-topic monitor
 func StreamLatestDataToBigQuery(ctx context.Context, m PubSubMessage) (err error) {
 
-	client, err := bigquery.NewClient(ctx, projectID)
-	if err != nil {
-		return
-	}
-	defer client.Close()
-	// Query the last entry, this will return multiple lines, one for each metric.
-	q := client.Query(`
-		SELECT * FROM homepage-961.idporten.navmetrics WHERE (timestamp) IN 
-			( SELECT MAX(timestamp) FROM homepage-961.idporten.navmetrics )
-		`)
-
-	it, err := q.Read(ctx)
-	if err != nil {
-		return
-	}
-	var values Metric
-	// Will zero out values when reaching the end. We only need the first entry this time.
-	err = it.Next(&values)
+	sink, err := NewBigQuerySink(ctx, projectID, datasetName)
 	if err != nil {
 		return
 	}
+	defer sink.Close()
 
-	// I assume we get so little data that we can gather it all in one go.
-	// we could reload everything if discrepancies arise over time.
-	fromTime := values.Timestamp.Add(time.Hour)
-	toTime := time.Now().UTC()
-	if fromTime.After(toTime) {
-		// Sanity check failed. If we run collection too fast, we
-		// shouldn´t do anyting.
-		return
-	}
-
-	series, err := Query(fromTime, toTime, OrgNr)
-	if err != nil {
-		return err
-	}
-
-	metrics := make([]Metric, 0)
-	for _, v := range series {
-		metrics = append(metrics, v.ToMetrics()...)
-	}
-
-	// Stream to BigQuery tables.
-	metricsTableRef := client.Dataset(datasetName).Table(MetricsTableName)
-	if err := metricsTableRef.Inserter().Put(ctx, metrics); err != nil {
-		return err
-	}
-
-	seriesTableRef := client.Dataset(datasetName).Table(tableName)
-	if err := seriesTableRef.Inserter().Put(ctx, series); err != nil {
-		return err
+	result, err := DefaultHarvester.Stream(ctx, sink, failOnUnknownMethod())
+	if result != nil {
+		result.Log(os.Stdout)
 	}
-
-	return
+	return err
 }
 
 // SendEverythingToBigquery proocesses all historical data and sends it to BigQuery.
@@ -165,15 +130,15 @@ func StreamLatestDataToBigQuery(ctx context.Context, m PubSubMessage) (err error
 //
 // Preparing BigQuery
 //
-// A new dataset is created if it doens´t exist. A shema is inferred from the
-// Statistikk struct and a a table is created for the data. If a table exist
-// all data is deleted.
+// A new dataset is created if it doens´t exist. Existing tables are deleted
+// and created again with the schema from StatistikkSchema and MetricSchema,
+// see BigQuerySink.
 //
 // Processing data
 //
-// All data is read from both organization numbers and merged using a map
-// structure. Once complete all entries are extracted and and the array
-// is sorted befre streaming the content to BigQuery.
+// All data is read from the organization numbers of DefaultHarvester and
+// merged with its strategy, see Harvester.Rebuild. The merged series is
+// sorted before streaming the content to BigQuery.
 //
 // Datastudio-friendly format
 //
@@ -181,182 +146,22 @@ func StreamLatestDataToBigQuery(ctx context.Context, m PubSubMessage) (err error
 // work a lot better.
 //
 func SendEverythingToBigquery() (err error) {
+	return SendEverythingToBigqueryContext(context.Background())
+}
 
-	ctx := context.Background()
-	client, err := bigquery.NewClient(ctx, projectID)
-	if err != nil {
-		return
-	}
-	defer client.Close()
-
-	// Create a dataset if it doesn´t exist.
-	if _, err := client.Dataset(datasetName).Metadata(ctx); err != nil {
-		meta := &bigquery.DatasetMetadata{
-			Description: "Statistikk om innlogginger fra idporten",
-			Location:    "EU", // See https://cloud.google.com/bigquery/docs/locations
-		}
-		if err := client.Dataset(datasetName).Create(ctx, meta); err != nil {
-			return err
-		}
-	}
-	schema, err := bigquery.InferSchema(Statistikk{})
-	if err != nil {
-		return
-	}
-	metaData := &bigquery.TableMetadata{
-		Schema:         schema,
-		ExpirationTime: time.Now().AddDate(2, 0, 0), // Table will be automatically deleted in 2 years.
-	}
-	tableRef := client.Dataset(datasetName).Table(tableName)
-
-	// Delete the table if it exists.
-	_, err = tableRef.Metadata(ctx)
-	if err == nil {
-		if err := tableRef.Delete(ctx); err != nil {
-			return err
-		}
-	}
-
-	if err := tableRef.Create(ctx, metaData); err != nil {
-		return err
-	}
-
-	log.Println("Slowly read the data from API to the large series.")
-	largeSeries := make([]Statistikk, 0)
-	{
-		limiter := time.Tick(500 * time.Millisecond)
-		fromDate := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
-		toDate := time.Now().In(time.UTC)
-		aDate := fromDate
-		const MonthIncrement = 5
-		for aDate.Before(toDate) {
-			log.Printf("Reading from %v to %v", aDate, aDate.AddDate(0, MonthIncrement, 0))
-			tmp, err := Query(aDate, aDate.AddDate(0, MonthIncrement, 0), OrgNr)
-			if err != nil {
-				return err
-			}
-			largeSeries = append(largeSeries, tmp...)
-			<-limiter
-			aDate = aDate.AddDate(0, MonthIncrement, 0)
-		}
-	}
-	log.Printf("Read a total of %v values from the large series", len(largeSeries))
-
-	log.Println("Slowly read the data from API to the small series")
-	smallSeries := make([]Statistikk, 0)
-	{
-		limiter := time.Tick(500 * time.Millisecond)
-		fromDate := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
-		toDate := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
-		aDate := fromDate
-		const MonthIncrement = 5
-		for aDate.Before(toDate) {
-			log.Printf("Reading from %v to %v", aDate, aDate.AddDate(0, MonthIncrement, 0))
-			tmp, err := Query(aDate, aDate.AddDate(0, MonthIncrement, 0), OldOrg)
-			if err != nil {
-				return err
-			}
-			smallSeries = append(smallSeries, tmp...)
-			<-limiter
-			aDate = aDate.AddDate(0, MonthIncrement, 0)
-		}
-	}
-	log.Printf("Read a total of %v values from the small series", len(largeSeries))
-
-	// Time needs to be in the same timezone since
-	collatorMap := make(map[time.Time]Statistikk, 0)
-	for _, v := range largeSeries {
-		v := v.CalcSum()
-		collatorMap[v.Timestamp] = v
-		if v.Timestamp.Year() < 1000 {
-			fmt.Println("Incorrect data in large series: ", v)
-		}
-	}
-
-	for _, v := range smallSeries {
-		t, ok := collatorMap[v.Timestamp]
-		if ok {
-			v = v.Add(t).CalcSum()
-		}
-		if v.Timestamp.Year() < 1000 {
-			log.Fatal("Feiled date ", v)
-		}
-		collatorMap[v.Timestamp] = v
-	}
-
-	collatedSeries := make([]Statistikk, 0, len(collatorMap))
-	for _, v := range collatorMap {
-		collatedSeries = append(collatedSeries, v)
-	}
-
-	sort.Slice(collatedSeries, func(i, j int) bool {
-		return collatedSeries[i].Timestamp.Before(collatedSeries[j].Timestamp)
-	})
-
-	fmt.Printf("Sucessfully processed %v lines ", len(collatedSeries))
-	fmt.Println("First object is", collatedSeries[0].Timestamp)
-	fmt.Println("Last object is", collatedSeries[len(collatedSeries)-1])
-
-	work := SplitStatistikkArrayIntoChunks(collatedSeries, 5000)
-	log.Printf("Beginning transmission to BigQuery, splitting workload into %v parts", len(work))
-	limiter := time.Tick(2000 * time.Millisecond)
-	for i := range work {
-		log.Printf("Submitting %v of %v parts, this one has  %v rows", i, len(work), len(work[i]))
-		if err := tableRef.Inserter().Put(ctx, work[i]); err != nil {
-			return err
-		}
-		<-limiter
-	}
-
-	//
-	// Reshape the data and send again to BigQuery
-	//
-
-	metrics := make([]Metric, 0)
-
-	for _, v := range collatedSeries {
-		metrics = append(metrics, v.ToMetrics()...)
-	}
-	fmt.Printf("Created %v lines of metrics", len(metrics))
-
-	//
-	// Create the table for metrics, delete table if an old one exists.
-	//
-
-	var MetricsTableName string = "navmetrics"
+// SendEverythingToBigqueryContext is like SendEverythingToBigquery but stops
+// reading from the API and writing to BigQuery when ctx is done.
+func SendEverythingToBigqueryContext(ctx context.Context) (err error) {
 
-	metricSchema, err := bigquery.InferSchema(Metric{})
+	sink, err := NewBigQuerySink(ctx, projectID, datasetName)
 	if err != nil {
 		return
 	}
-	metricsmetaData := &bigquery.TableMetadata{
-		Schema:         metricSchema,
-		ExpirationTime: time.Now().AddDate(2, 0, 0), // Table will be automatically deleted in 2 years.
-	}
-	metricsTableRef := client.Dataset(datasetName).Table(MetricsTableName)
-
-	// Delete the table if it exists.
-	_, err = metricsTableRef.Metadata(ctx)
-	if err == nil {
-		if err := metricsTableRef.Delete(ctx); err != nil {
-			return err
-		}
-	}
-	if err := metricsTableRef.Create(ctx, metricsmetaData); err != nil {
-		return err
-	}
-
-	// Split workload into chungs and send to BigQuery.
+	defer sink.Close()
 
-	metricsWork := SplitMetricArrayIntoChunks(metrics, 5000)
-	log.Printf("Beginning transmission to BigQuery, splitting workload into %v parts", len(metricsWork))
-	metricsLimiter := time.Tick(2000 * time.Millisecond)
-	for i := range metricsWork {
-		log.Printf("Submitting %v of %v metric parts, this one has  %v rows", i, len(metrics), len(metricsWork[i]))
-		if err := metricsTableRef.Inserter().Put(ctx, metricsWork[i]); err != nil {
-			return err
-		}
-		<-metricsLimiter
+	result, err := DefaultHarvester.Rebuild(ctx, sink)
+	if result != nil {
+		result.Log(os.Stdout)
 	}
 	return err
 }
@@ -3,6 +3,7 @@ package idharvest
 import (
 	"context"
 	"encoding/json"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -65,7 +66,11 @@ func TestDateToString(t *testing.T) {
 	}
 }
 
+// TestQuery replays the response for 1 May 2020 through DefaultClient, see
+// cassetteClient. Run it with IDHARVEST_RECORD=true to query the real API.
 func TestQuery(t *testing.T) {
+	defer func(c *Client) { DefaultClient = c }(DefaultClient)
+	DefaultClient = cassetteClient()
 	stat, err := Query(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
 		time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
 		OrgNr)
@@ -97,8 +102,18 @@ func TestUnmarshal(t *testing.T) {
 
 }
 
-// TestStreamLatestDataToBigQuery
+// envBigQueryTest enables the tests which write to the BigQuery dataset of
+// the cloud function with the default credentials.
+const envBigQueryTest = "IDHARVEST_TEST_BIGQUERY"
+
+// TestStreamLatestDataToBigQuery runs the cloud function against the real
+// API and BigQuery, only if envBigQueryTest is true:
+//
+//	IDHARVEST_TEST_BIGQUERY=true go test -run StreamLatest .
 func TestStreamLatestDataToBigQuery(t *testing.T) {
+	if os.Getenv(envBigQueryTest) != "true" {
+		t.Skip("Set ", envBigQueryTest, "=true to write to BigQuery")
+	}
 	err := StreamLatestDataToBigQuery(context.Background(), PubSubMessage{[]byte("hello")})
 	if err != nil {
 		t.Error(err)
@@ -0,0 +1,47 @@
+package idharvest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(2, 2)
+	now := l.last
+	want := []time.Duration{0, 0, 500 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := l.reserve(now); got != w {
+			t.Errorf("reserve %v = %v, want %v", i, got, w)
+		}
+	}
+	// After a second the two reserved tokens are paid back and the bucket
+	// is empty again.
+	if got := l.reserve(now.Add(time.Second)); got != 500*time.Millisecond {
+		t.Error("Expected the bucket to be refilled at the rate, got ", got)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Error("Waited too little: ", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewRateLimiter(0.001, 1)
+	slow.Wait(ctx)
+	if err := slow.Wait(ctx); err != context.Canceled {
+		t.Error("Expected context.Canceled, got ", err)
+	}
+	if got := slow.reserve(time.Now()); got != 0 {
+		t.Error("A cancelled Wait() should not take a token, got a delay of ", got)
+	}
+}
@@ -0,0 +1,31 @@
+package idharvest
+
+import (
+	"log"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// TimeZone is the time zone of Norwegian calendar days, used for Lokaltid
+// and the rollups.
+const TimeZone = "Europe/Oslo"
+
+// Oslo is the location of TimeZone. If the time zone database is missing it
+// falls back to UTC with a warning, the Go 1.13 runtime of Cloud Functions
+// includes it.
+var Oslo = loadLocation(TimeZone)
+
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Warning: %v, using UTC.", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// LocalTime returns the wall clock time in Oslo at t.
+func LocalTime(t time.Time) civil.DateTime {
+	return civil.DateTimeOf(t.In(Oslo))
+}
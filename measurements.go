@@ -0,0 +1,337 @@
+package idharvest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The names the API uses for the authentication methods known when this
+// package was written.
+const (
+	KeyMinIDPassport   = "MinID passport"
+	KeyCommfides       = "Commfides"
+	KeyBuypassPassport = "Buypass passport"
+	KeyEIDAS           = "eIDAS"
+	KeyMinID           = "MinID"
+	KeyBankIDMobil     = "BankID mobil"
+	KeyMinIDOTC        = "MinID OTC"
+	KeyAntall          = "Antall"
+	KeyBuyPass         = "BuyPass"
+	KeyMinIDPIN        = "MinID PIN"
+	KeyFederated       = "Federated"
+	KeyBankID          = "BankID"
+)
+
+// Method describes an authentication method reported by the API. The
+// registry in Methods drives decoding, the BigQuery columns, the reshaping
+// into metrics, addition and summation, so that they can't disagree.
+type Method struct {
+	Key          string // Name used by the API, "BankID mobil".
+	Column       string // Column in the wide BigQuery table, "bankid_mobil".
+	Metode       Metode // Name in the long BigQuery table, "BankIDMobil".
+	InSum        bool   // Counted in Statistikk.Sum.
+	InMetrics    bool   // Emitted by ToMetrics and counted in the groupings.
+	Label        string // Norwegian display label.
+	EnglishLabel string // English display label.
+
+	// Family is the provider family the method belongs to, for instance
+	// all MinID variants belong to MinID. Empty for Antall and Federated.
+	Family string
+	// Level is the eIDAS assurance level of the method.
+	Level AssuranceLevel
+}
+
+// Methods is the registry of known authentication methods in the order of
+// the BigQuery columns.
+//
+// Antall is the total reported by the API and Federated counts logins
+// reusing a session from another service. Neither is a method of its own,
+// so they are left out of the sum. They are deliberately left out of the
+// metrics as well, so that the metrics of an hour add up to Sum; both are
+// still in the wide table. A method with InMetrics must have InSum.
+var Methods = []Method{
+	{
+		Key:          KeyMinIDPassport,
+		Column:       "minid_passport",
+		Metode:       MinIDPassport,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "MinID passport",
+		EnglishLabel: "MinID passport",
+		Family:       FamilyMinID,
+		Level:        LevelHigh,
+	},
+	{
+		Key:          KeyCommfides,
+		Column:       "comfides",
+		Metode:       Commfides,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "Commfides",
+		EnglishLabel: "Commfides",
+		Family:       FamilyCommfides,
+		Level:        LevelHigh,
+	},
+	{
+		Key:          KeyBuypassPassport,
+		Column:       "buypass_passport",
+		Metode:       BuypassPassport,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "Buypass passport",
+		EnglishLabel: "Buypass passport",
+		Family:       FamilyBuypass,
+		Level:        LevelHigh,
+	},
+	{
+		Key:          KeyEIDAS,
+		Column:       "eidas",
+		Metode:       EIDAS,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "eIDAS",
+		EnglishLabel: "eIDAS",
+		Family:       FamilyEIDAS,
+		Level:        LevelUnknown,
+	},
+	{
+		Key:          KeyMinID,
+		Column:       "minid",
+		Metode:       MinID,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "MinID",
+		EnglishLabel: "MinID",
+		Family:       FamilyMinID,
+		Level:        LevelSubstantial,
+	},
+	{
+		Key:          KeyBankIDMobil,
+		Column:       "bankid_mobil",
+		Metode:       BankIDMobil,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "BankID på mobil",
+		EnglishLabel: "BankID on mobile",
+		Family:       FamilyBankID,
+		Level:        LevelHigh,
+	},
+	{
+		Key:          KeyMinIDOTC,
+		Column:       "minid_otc",
+		Metode:       MinIDOTC,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "MinID engangskode",
+		EnglishLabel: "MinID one-time code",
+		Family:       FamilyMinID,
+		Level:        LevelSubstantial,
+	},
+	{
+		Key:          KeyAntall,
+		Column:       "antall",
+		Metode:       Antall,
+		InSum:        false,
+		InMetrics:    false,
+		Label:        "Antall innlogginger",
+		EnglishLabel: "Total logins",
+	},
+	{
+		Key:          KeyBuyPass,
+		Column:       "buypass",
+		Metode:       Buypass,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "Buypass",
+		EnglishLabel: "Buypass",
+		Family:       FamilyBuypass,
+		Level:        LevelHigh,
+	},
+	{
+		Key:          KeyMinIDPIN,
+		Column:       "minid_pin",
+		Metode:       MinIDPIN,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "MinID PIN-kode",
+		EnglishLabel: "MinID PIN code",
+		Family:       FamilyMinID,
+		Level:        LevelSubstantial,
+	},
+	{
+		Key:          KeyFederated,
+		Column:       "federated",
+		Metode:       Federated,
+		InSum:        false,
+		InMetrics:    false,
+		Label:        "Føderert innlogging",
+		EnglishLabel: "Federated login",
+	},
+	{
+		Key:          KeyBankID,
+		Column:       "bankid",
+		Metode:       BankID,
+		InSum:        true,
+		InMetrics:    true,
+		Label:        "BankID",
+		EnglishLabel: "BankID",
+		Family:       FamilyBankID,
+		Level:        LevelHigh,
+	},
+}
+
+// LookupMethod returns the registered method with the API name key.
+func LookupMethod(key string) (Method, bool) {
+	for _, m := range Methods {
+		if m.Key == key {
+			return m, true
+		}
+	}
+	return Method{}, false
+}
+
+// methodFor returns the registered method for key, or a description of a
+// method unknown to this package. Unknown methods are counted in the sum,
+// a new way to log in is most likely a method of its own.
+func methodFor(key string) Method {
+	if m, ok := LookupMethod(key); ok {
+		return m
+	}
+	return Method{
+		Key:          key,
+		Metode:       Metode(metodeName(key)),
+		InSum:        true,
+		InMetrics:    true,
+		Label:        key,
+		EnglishLabel: key,
+		Family:       metodeName(key),
+		Level:        LevelUnknown,
+	}
+}
+
+// Measurements holds the number of logins for each authentication method,
+// keyed by the name the API uses, for instance "BankID mobil". Methods
+// added to the API after this package was written are kept as well, so
+// they are counted and streamed without a code change.
+type Measurements map[string]int
+
+// IsKnown reports whether key is one of the methods in Methods.
+func IsKnown(key string) bool {
+	_, ok := LookupMethod(key)
+	return ok
+}
+
+// Unknown returns the keys of the methods not known to this package,
+// sorted.
+func (m Measurements) Unknown() []string {
+	keys := make([]string, 0)
+	for k := range m {
+		if !IsKnown(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Clone returns a copy of m which can be changed without changing m.
+func (m Measurements) Clone() Measurements {
+	c := make(Measurements, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Sum returns the number of logins counted over all methods included in the
+// sum, known or not.
+func (m Measurements) Sum() (sum int) {
+	for k, v := range m {
+		if methodFor(k).InSum {
+			sum += v
+		}
+	}
+	return sum
+}
+
+// Plus returns the sum of m and o for every method in either of them.
+func (m Measurements) Plus(o Measurements) Measurements {
+	c := m.Clone()
+	for k, v := range o {
+		c[k] += v
+	}
+	return c
+}
+
+// MinIDPassport returns the number of logins with MinID passport.
+func (m Measurements) MinIDPassport() int { return m[KeyMinIDPassport] }
+
+// Commfides returns the number of logins with Commfides.
+func (m Measurements) Commfides() int { return m[KeyCommfides] }
+
+// BuypassPassport returns the number of logins with Buypass passport.
+func (m Measurements) BuypassPassport() int { return m[KeyBuypassPassport] }
+
+// EIDAS returns the number of logins with a foreign eID through eIDAS.
+func (m Measurements) EIDAS() int { return m[KeyEIDAS] }
+
+// MinID returns the number of logins with MinID.
+func (m Measurements) MinID() int { return m[KeyMinID] }
+
+// BankIDMobil returns the number of logins with BankID on mobile.
+func (m Measurements) BankIDMobil() int { return m[KeyBankIDMobil] }
+
+// MinIDOTC returns the number of logins with MinID one time codes.
+func (m Measurements) MinIDOTC() int { return m[KeyMinIDOTC] }
+
+// Antall returns the total number of logins reported by the API.
+func (m Measurements) Antall() int { return m[KeyAntall] }
+
+// BuyPass returns the number of logins with Buypass.
+func (m Measurements) BuyPass() int { return m[KeyBuyPass] }
+
+// MinIDPIN returns the number of logins with MinID PIN codes.
+func (m Measurements) MinIDPIN() int { return m[KeyMinIDPIN] }
+
+// Federated returns the number of logins reusing a session from another
+// service.
+func (m Measurements) Federated() int { return m[KeyFederated] }
+
+// BankID returns the number of logins with BankID.
+func (m Measurements) BankID() int { return m[KeyBankID] }
+
+// metodeName turns an API key unknown to this package into a Metode name.
+// A key of letters and digits is used as it is, unless ParseMetode takes
+// it for a registered method, as it would "Minid". Other keys are made of
+// their words followed by a hash of the key, so "Foo-ID" gives
+// "FooID65fb1a33": anything but letters and digits separates words, and the
+// hash keeps "Foo ID" and "Foo-ID" apart and "BankID-mobil" from
+// BankIDMobil. The name always passes checkMetode.
+func metodeName(key string) string {
+	if checkMetode(key) == nil {
+		if _, err := ParseMetode(key); err != nil {
+			return key
+		}
+	}
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		words = []string{"Metode"}
+	}
+	var b strings.Builder
+	for _, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(word[size:])
+	}
+	h := fnv.New32a()
+	io.WriteString(h, key)
+	fmt.Fprintf(&b, "%08x", h.Sum32())
+	return b.String()
+}
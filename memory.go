@@ -0,0 +1,130 @@
+package idharvest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// MemorySink is a Sink keeping the tables in memory, for tests and dry
+// runs. Like BigQuery it rejects rows which don't match StatistikkSchema
+// and MetricSchema. It is safe for concurrent use.
+type MemorySink struct {
+	mu      sync.Mutex
+	series  Series
+	tables  map[string][]Metric
+	flushes int
+}
+
+// NewMemorySink returns an empty sink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{tables: make(map[string][]Metric)}
+}
+
+// Latest implements Sink, like SELECT MAX(timestamp) FROM navmetrics.
+func (s *MemorySink) Latest(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest time.Time
+	for _, m := range s.tables[MetricsTableName] {
+		if m.Timestamp.After(latest) {
+			latest = m.Timestamp
+		}
+	}
+	return latest, nil
+}
+
+// WriteSeries implements Sink.
+func (s *MemorySink) WriteSeries(ctx context.Context, series Series) error {
+	schema, err := StatistikkSchema()
+	if err != nil {
+		return err
+	}
+	for _, v := range series {
+		if err := checkSaver(schema, v); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series = append(s.series, series...)
+	return nil
+}
+
+// WriteMetrics implements Sink.
+func (s *MemorySink) WriteMetrics(ctx context.Context, table string, metrics []Metric) error {
+	schema := MetricSchema()
+	for _, m := range metrics {
+		if err := checkSaver(schema, m); err != nil {
+			return fmt.Errorf("%v: %v", table, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table] = append(s.tables[table], metrics...)
+	return nil
+}
+
+// Reset implements Sink.
+func (s *MemorySink) Reset(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series = nil
+	s.tables = make(map[string][]Metric)
+	return nil
+}
+
+// Flush implements Sink. It only counts the calls, see Flushes.
+func (s *MemorySink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+	return nil
+}
+
+// Series returns a copy of the series table, in the order written.
+func (s *MemorySink) Series() Series {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(Series(nil), s.series...)
+}
+
+// Metrics returns a copy of the named metrics table, in the order written.
+func (s *MemorySink) Metrics(table string) []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Metric(nil), s.tables[table]...)
+}
+
+// Flushes returns the number of calls to Flush, one for each successful run.
+func (s *MemorySink) Flushes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushes
+}
+
+// checkSaver fails if the row saved by v has columns which are not in
+// schema, or lacks a required column, as a streaming insert into BigQuery
+// would.
+func checkSaver(schema bigquery.Schema, v bigquery.ValueSaver) error {
+	row, _, err := v.Save()
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]bool, len(schema))
+	for _, f := range schema {
+		fields[f.Name] = true
+		if _, ok := row[f.Name]; f.Required && !ok {
+			return fmt.Errorf("idharvest: missing required column %v in %v", f.Name, v)
+		}
+	}
+	for name := range row {
+		if !fields[name] {
+			return fmt.Errorf("idharvest: no such column %v in %v", name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemorySink(t *testing.T) {
+	ctx := context.Background()
+	sink := NewMemorySink()
+	if latest, err := sink.Latest(ctx); err != nil || !latest.IsZero() {
+		t.Error("Latest() of an empty sink, got ", latest, err)
+	}
+	s := hours(OrgNr, 1, 0, 5, 3)
+	if err := sink.WriteMetrics(ctx, MetricsTableName, s.Metrics()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteMetrics(ctx, GroupedMetricsTableName, hours(OrgNr, 1, 7).Metrics()); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _ := sink.Latest(ctx); latest.Hour() != 5 {
+		t.Error("Latest() should be the last hour in the metrics table, got ", latest)
+	}
+	if err := checkSaver(MetricSchema(), s[0]); err == nil {
+		t.Error("A row with columns not in the schema should be rejected")
+	}
+	if err := sink.Reset(ctx); err != nil || len(sink.Metrics(MetricsTableName)) != 0 {
+		t.Error("Reset() should empty the tables, got ", sink.Metrics(MetricsTableName))
+	}
+}
+
+func TestStreamEmptySink(t *testing.T) {
+	h := &Harvester{Client: &Client{BaseURL: "http://localhost:0", Retry: NoRetry}}
+	if _, err := h.Stream(context.Background(), NewMemorySink(), false); err != ErrEmptySink {
+		t.Error("Stream() into an empty sink should fail with ErrEmptySink, got ", err)
+	}
+}
+
+func TestRebuildAndStream(t *testing.T) {
+	ts := httptest.NewServer(orgHandler(t, map[Org]int{OrgNr: 1, OldOrg: 10}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Hour)
+	h := &Harvester{
+		Client: &Client{BaseURL: ts.URL, HTTPClient: ts.Client(), Retry: NoRetry},
+		Orgs: []OrgPeriod{
+			{Org: OrgNr, From: now.Add(-48 * time.Hour)},
+			{Org: OldOrg, From: now.Add(-48 * time.Hour), To: now.Add(-24 * time.Hour)},
+		},
+		// Half past, so the test doesn't depend on crossing an hour.
+		Now: func() time.Time { return now.Add(30 * time.Minute) },
+	}
+	sink := NewMemorySink()
+	result, err := h.Rebuild(ctx, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := sink.Series()
+	if result.Rows != len(series) || len(series) != 49 {
+		t.Fatalf("Rebuild() wrote %v rows, reported %v, want 49", len(series), result.Rows)
+	}
+	if !result.From.Equal(now.Add(-48 * time.Hour)) {
+		t.Error("RunResult.From should be the first hour, got ", result.From)
+	}
+	if series[0].Sum != 11 || series[24].Sum != 11 || series[25].Sum != 1 {
+		t.Error("Rebuild() should sum the organizations, got ", series[0], series[24], series[25])
+	}
+	orgMetrics := sink.Metrics(OrgMetricsTableName)
+	// The API includes the hour at To, so OldOrg has 25 hours.
+	if result.OrgMetrics != len(orgMetrics) || len(orgMetrics) != 74*len(hours(OrgNr, 1, 0).Metrics()) {
+		t.Error("Rebuild() should write a breakdown of 74 organization hours, got ", len(orgMetrics))
+	}
+	if len(sink.Metrics(MetricsTableName)) != result.Metrics || len(sink.Metrics(GroupedMetricsTableName)) != result.GroupedMetrics {
+		t.Error("RunResult doesn't match the tables ", result)
+	}
+	if sink.Flushes() != 1 {
+		t.Error("Rebuild() should flush once, got ", sink.Flushes())
+	}
+
+	// Nothing new until the next hour.
+	if result, err := h.Stream(ctx, sink, false); err != nil || result != nil {
+		t.Error("Stream() right after Rebuild() should do nothing, got ", result, err)
+	}
+
+	// Drop the last 5 hours, like a stream which hasn't run for a while.
+	if err := sink.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	old := series.Between(time.Time{}, now.Add(-4*time.Hour))
+	if err := sink.WriteMetrics(ctx, MetricsTableName, old.Metrics()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteSeries(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	result, err = h.Stream(ctx, sink, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.From.Equal(now.Add(-4*time.Hour)) || result.Rows != 5 {
+		t.Error("Stream() should harvest the last 5 hours, got ", result)
+	}
+	streamed := sink.Series()
+	if len(streamed) != 49 || len(streamed.Dedup()) != 49 || !streamed.IsSorted() {
+		t.Error("Stream() should continue where the sink ends, got ", len(streamed))
+	}
+	if latest, _ := sink.Latest(ctx); !latest.Equal(now) {
+		t.Error("Latest() after Stream() should be the current hour, got ", latest)
+	}
+}
+
+func TestStreamStrict(t *testing.T) {
+	ts := httptest.NewServer(orgHandler(t, map[Org]int{OrgNr: 1}))
+	defer ts.Close()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Hour)
+	h := &Harvester{
+		Client: &Client{BaseURL: ts.URL, HTTPClient: ts.Client(), Retry: NoRetry},
+		Orgs:   []OrgPeriod{{Org: OrgNr, From: now.Add(-48 * time.Hour)}},
+	}
+	sink := NewMemorySink()
+	last := Statistikk{Timestamp: now.Add(-2 * time.Hour), Measurements: Measurements{"Passkey": 1}}
+	if err := sink.WriteMetrics(ctx, MetricsTableName, last.ToMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	// orgHandler only returns MinID, add an unknown method when merging.
+	h.Merge = MergeStrategy{Name: "passkey", Merge: func(orgs []Org, series []Series) Series {
+		merged := MergeSum.Merge(orgs, series)
+		for i := range merged {
+			merged[i].Measurements = merged[i].Measurements.Plus(Measurements{"Passkey": 1})
+		}
+		return merged
+	}}
+	result, err := h.Stream(ctx, sink, true)
+	var unknown *UnknownMethodError
+	if !errors.As(err, &unknown) || len(result.UnknownMethods) != 1 {
+		t.Fatal("Stream() should fail on unknown methods, got ", err)
+	}
+	if len(sink.Metrics(MetricsTableName)) != len(last.ToMetrics()) || sink.Flushes() != 0 {
+		t.Error("Stream() should not write anything when failing on unknown methods")
+	}
+}
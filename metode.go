@@ -0,0 +1,125 @@
+package idharvest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Metode identifies an authentication method in the long BigQuery table and
+// in Metric, for instance "BankIDMobil". The registered values are the
+// constants below; a method unknown to this package gets a Metode made from
+// its API name, see Metode.Valid.
+type Metode string
+
+// The registered methods.
+const (
+	MinIDPassport   Metode = "MinIDPassport"
+	Commfides       Metode = "Commfides"
+	BuypassPassport Metode = "BuypassPassport"
+	EIDAS           Metode = "EIDAS"
+	MinID           Metode = "MinID"
+	BankIDMobil     Metode = "BankIDMobil"
+	MinIDOTC        Metode = "MinIDOTC"
+	Buypass         Metode = "Buypass"
+	MinIDPIN        Metode = "MinIDPIN"
+	BankID          Metode = "BankID"
+	Antall          Metode = "Antall"
+	Federated       Metode = "Federated"
+)
+
+// Language selects the language of display names.
+type Language int
+
+const (
+	Norwegian Language = iota
+	English
+)
+
+func (m Metode) String() string {
+	return string(m)
+}
+
+// Method returns the registered method for m.
+func (m Metode) Method() (Method, bool) {
+	for _, v := range Methods {
+		if v.Metode == m {
+			return v, true
+		}
+	}
+	return Method{}, false
+}
+
+// Valid reports whether m is one of the registered methods.
+func (m Metode) Valid() bool {
+	_, ok := m.Method()
+	return ok
+}
+
+// DisplayName returns a human readable name of m for dashboards. Methods
+// unknown to this package are shown as they are.
+func (m Metode) DisplayName(lang Language) string {
+	v, ok := m.Method()
+	if !ok {
+		return string(m)
+	}
+	if lang == English {
+		return v.EnglishLabel
+	}
+	return v.Label
+}
+
+// ParseMetode returns the registered method named s. Besides the Metode
+// itself, ignoring case, the name used by the API and the display names are
+// accepted, so "bankidmobil", "BankID mobil" and "BankID på mobil" all give
+// BankIDMobil.
+func ParseMetode(s string) (Metode, error) {
+	s = strings.TrimSpace(s)
+	for _, v := range Methods {
+		if strings.EqualFold(s, string(v.Metode)) ||
+			strings.EqualFold(s, v.Key) ||
+			strings.EqualFold(s, v.Label) ||
+			strings.EqualFold(s, v.EnglishLabel) {
+			return v.Metode, nil
+		}
+	}
+	return "", fmt.Errorf("idharvest: unknown metode %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Metode) MarshalText() ([]byte, error) {
+	if err := checkMetode(string(m)); err != nil {
+		return nil, err
+	}
+	return []byte(m), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Registered methods are
+// parsed with ParseMetode. Other values are accepted if they look like a
+// Metode, so that methods added to the API after this package was written
+// survive a round trip.
+func (m *Metode) UnmarshalText(text []byte) error {
+	if v, err := ParseMetode(string(text)); err == nil {
+		*m = v
+		return nil
+	}
+	if err := checkMetode(string(text)); err != nil {
+		return err
+	}
+	*m = Metode(text)
+	return nil
+}
+
+// checkMetode fails unless s is a non-empty sequence of letters and digits.
+func checkMetode(s string) error {
+	if s == "" {
+		return errors.New("idharvest: empty metode")
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return fmt.Errorf("idharvest: invalid metode %q", s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package idharvest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestParseMetode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Metode
+		wantErr bool
+	}{
+		{in: "BankIDMobil", want: BankIDMobil},
+		{in: "bankidmobil", want: BankIDMobil},
+		{in: "BankID mobil", want: BankIDMobil},
+		{in: "BankID på mobil", want: BankIDMobil},
+		{in: "BankID on mobile", want: BankIDMobil},
+		{in: " Buypass ", want: Buypass},
+		{in: "BuyPass", want: Buypass},
+		{in: "FooID", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMetode(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMetode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMetode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetodeText(t *testing.T) {
+	type row struct {
+		Metode Metode `json:"metode"`
+	}
+	for _, in := range []Metode{MinIDOTC, "FooID"} {
+		b, err := json.Marshal(row{in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out row
+		if err := json.Unmarshal(b, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Metode != in {
+			t.Errorf("Round trip of %v gave %v", in, out.Metode)
+		}
+	}
+	var m Metode
+	if err := m.UnmarshalText([]byte("minid otc")); err != nil || m != MinIDOTC {
+		t.Errorf("UnmarshalText() = %v, %v", m, err)
+	}
+	for _, bad := range []string{"", "Foo ID", "Foo;"} {
+		if err := m.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) should fail", bad)
+		}
+	}
+	if _, err := Metode("").MarshalText(); err == nil {
+		t.Error("MarshalText of an empty Metode should fail")
+	}
+}
+
+func TestUnknownMetodeRoundTrip(t *testing.T) {
+	for key, want := range map[string]string{
+		"FooID":         "FooID",
+		"Foo ID":        "FooID3c2bb410",
+		"Foo-ID":        "FooID65fb1a33",
+		"Foo-ID (test)": "FooIDTest2cfeae2a",
+		"Bar/ID 2":      "BarID22eb3bd9c",
+		"Før ID":        "FørIDa4c8a2cd",
+		"-":             "Metode280c9438",
+		"Minid":         "Minide73a127a",
+		"BankID-mobil":  "BankIDMobilbfb1fc78",
+	} {
+		s := Statistikk{Measurements: Measurements{key: 1}}
+		metrics := s.ToMetrics()
+		m := metrics[len(metrics)-1].Metode
+		if string(m) != want {
+			t.Errorf("The Metode of %q is %q, want %q", key, m, want)
+		}
+		b, err := m.MarshalText()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var out Metode
+		if err := out.UnmarshalText(b); err != nil || out != m {
+			t.Errorf("Round trip of %v gave %v, %v", m, out, err)
+		}
+	}
+}
+
+// TestUnknownMetodeCollisions checks that unknown keys which only differ in
+// punctuation are counted apart, and apart from the registered methods.
+func TestUnknownMetodeCollisions(t *testing.T) {
+	s := Statistikk{Measurements: Measurements{KeyBankIDMobil: 1, "BankID-mobil": 2, "Foo ID": 4, "Foo-ID": 8}}
+	antall := make(map[Metode]int)
+	for _, m := range s.ToMetrics() {
+		if _, dup := antall[m.Metode]; dup {
+			t.Error("Two methods share the Metode ", m.Metode)
+		}
+		antall[m.Metode] = m.Antall
+	}
+	if antall[BankIDMobil] != 1 || len(antall) != len(s.ToMetrics()) {
+		t.Error("Unknown methods should not be counted as registered ones, got ", antall)
+	}
+}
+
+func TestMetodeDisplayName(t *testing.T) {
+	if got := MinIDOTC.DisplayName(Norwegian); got != "MinID engangskode" {
+		t.Error("Norwegian: ", got)
+	}
+	if got := MinIDOTC.DisplayName(English); got != "MinID one-time code" {
+		t.Error("English: ", got)
+	}
+	if got := Metode("FooID").DisplayName(English); got != "FooID" {
+		t.Error("Unknown: ", got)
+	}
+	if !BankID.Valid() || Metode("FooID").Valid() {
+		t.Error("Valid() is wrong")
+	}
+}
+
+func TestMetricSaveLoad(t *testing.T) {
+	schema := MetricSchema()
+	ts := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	in := Metric{Timestamp: ts, Metode: BankIDMobil, Antall: 42, Gruppering: "familie", Lokaltid: LocalTime(ts), Org: OldOrg}
+	saved, _, err := in.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRow(t, schema, saved)
+	row := make([]bigquery.Value, len(schema))
+	for i, f := range schema {
+		row[i] = saved[f.Name]
+		if n, ok := row[i].(int); ok {
+			// BigQuery returns integers as int64.
+			row[i] = int64(n)
+		}
+	}
+	var out Metric
+	if err := out.Load(row, schema); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("Load() = %v, want %v", out, in)
+	}
+}
+
+// TestGroupedMetricLoad checks that groups are loaded as they are, even
+// when they aren't valid methods or look like one in another case.
+func TestGroupedMetricLoad(t *testing.T) {
+	schema := MetricSchema()
+	for _, group := range []string{"Høy nivå", "minid", string(LevelHigh)} {
+		in := Metric{Timestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), Metode: Metode(group), Antall: 1, Gruppering: "egen"}
+		saved, _, _ := in.Save()
+		row := make([]bigquery.Value, len(schema))
+		for i, f := range schema {
+			row[i] = saved[f.Name]
+			if n, ok := row[i].(int); ok {
+				row[i] = int64(n)
+			}
+		}
+		var out Metric
+		if err := out.Load(row, schema); err != nil || out != in {
+			t.Errorf("Load() = %v, %v, want %v", out, err, in)
+		}
+	}
+}
@@ -1,119 +1,216 @@
 package idharvest
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
 	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 )
 
 // Statistikk contains the API result with mapping to It was initially generated
 // automaticly from the json-results of a REST-call last automated update on 23.
-// november 2020. The field sum was added as a convenience for reporting;
-// however this approach will fail when a new method of authentication is added
-// to the reporting. Statistikk represents a single point of meassurement, while
-// the result is an array of Statistikk:
+// november 2020. The field sum was added as a convenience for reporting.
+// Statistikk represents a single point of meassurement, while the result is an
+// array of Statistikk:
 //
 //		stat := make([]Statistikk, 0)
 //
 // What do to when a new method of authentication is added
 //
+// Measurements keeps every method returned by the API, and new methods are
+// included in Sum and in ToMetrics right away. In the wide BigQuery table they
+// are stored under measurements.other until a column is added.
+//
 // BigQuery is immutable on data in the streaming buffer, the change strategy is
 // to add the field and later make changes to historical data a few days later.
 //
 type Statistikk struct {
-	Timestamp    time.Time `json:"timestamp" bigquery:"timestamp"`
-	Measurements struct {
-		MinIDPassport   int `json:"MinID passport" bigquery:"minid_passport"`
-		Commfides       int `json:"Commfides" bigquery:"comfides"`
-		BuypassPassport int `json:"Buypass passport" bigquery:"buypass_passport"`
-		EIDAS           int `json:"eIDAS" bigquery:"eidas"`
-		MinID           int `json:"MinID" bigquery:"minid"`
-		BankIDMobil     int `json:"BankID mobil" bigquery:"bankid_mobil"`
-		MinIDOTC        int `json:"MinID OTC" bigquery:"minid_otc"`
-		Antall          int `json:"Antall" bigquery:"antall"`
-		BuyPass         int `json:"BuyPass" bigquery:"buypass"`
-		MinIDPIN        int `json:"MinID PIN" bigquery:"minid_pin"`
-		Federated       int `json:"Federated" bigquery:"federated"`
-		BankID          int `json:"BankID" bigquery:"bankid"`
-	} `json:"measurements" bigquery:"measurements"`
-	Categories struct {
-		TEOrgnum string `json:"TE-orgnum"`
-	} `json:"categories" bigquery:"categories"`
-	Sum int `json:"sum,omitempty" bigquery:"sum"` // Privat kategori for summering.
-}
-
-// ToMetrics splits a single Statistikk structure to
-// one metric for each row.
-func (s Statistikk) ToMetrics() (metrics []Metric) {
-	metrics = make([]Metric, 0)
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    MinIDPassport,
-		Antall:    s.Measurements.MinIDPassport,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    Commfides,
-		Antall:    s.Measurements.Commfides,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    Buypass,
-		Antall:    s.Measurements.BuyPass,
-	})
+	Timestamp    time.Time    `json:"timestamp" bigquery:"timestamp"`
+	Measurements Measurements `json:"measurements" bigquery:"measurements"`
+	Categories   Categories   `json:"categories" bigquery:"categories"`
+	Sum          int          `json:"sum,omitempty" bigquery:"sum"` // Privat kategori for summering.
+}
 
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    BuypassPassport,
-		Antall:    s.Measurements.BuypassPassport,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    EIDAS,
-		Antall:    s.Measurements.EIDAS,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    MinID,
-		Antall:    s.Measurements.MinID,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    BankIDMobil,
-		Antall:    s.Measurements.BankIDMobil,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    MinIDOTC,
-		Antall:    s.Measurements.MinIDOTC,
-	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    MinIDPIN,
-		Antall:    s.Measurements.MinIDPIN,
+// StatistikkSchema returns the schema of the wide BigQuery table. The
+// measurements record has a column for each known method and a repeated
+// record, other, for methods added later.
+func StatistikkSchema() (bigquery.Schema, error) {
+	measurements := make(bigquery.Schema, 0, len(Methods)+1)
+	for _, v := range Methods {
+		measurements = append(measurements, &bigquery.FieldSchema{
+			Name:     v.Column,
+			Type:     bigquery.IntegerFieldType,
+			Required: true,
+		})
+	}
+	measurements = append(measurements, &bigquery.FieldSchema{
+		Name:     "other",
+		Type:     bigquery.RecordFieldType,
+		Repeated: true,
+		Schema: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType, Required: true},
+			{Name: "antall", Type: bigquery.IntegerFieldType, Required: true},
+		},
 	})
-	metrics = append(metrics, Metric{
-		Timestamp: s.Timestamp,
-		Metode:    BankID,
-		Antall:    s.Measurements.BankID,
+	categories, err := bigquery.InferSchema(Categories{})
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.Schema{
+		{Name: "timestamp", Type: bigquery.TimestampFieldType, Required: true},
+		{Name: "measurements", Type: bigquery.RecordFieldType, Required: true, Schema: measurements},
+		{Name: "categories", Type: bigquery.RecordFieldType, Required: true, Schema: categories},
+		{Name: "sum", Type: bigquery.IntegerFieldType, Required: true},
+	}, nil
+}
+
+// Save implements bigquery.ValueSaver with a row matching StatistikkSchema.
+func (s Statistikk) Save() (row map[string]bigquery.Value, insertID string, err error) {
+	measurements := make(map[string]bigquery.Value, len(Methods)+1)
+	for _, v := range Methods {
+		measurements[v.Column] = s.Measurements[v.Key]
+	}
+	other := make([]bigquery.Value, 0)
+	for _, k := range s.Measurements.Unknown() {
+		other = append(other, map[string]bigquery.Value{"name": k, "antall": s.Measurements[k]})
+	}
+	measurements["other"] = other
+
+	categories := make([]bigquery.Value, 0, len(s.Categories.Other))
+	for _, v := range s.Categories.Other {
+		categories = append(categories, map[string]bigquery.Value{"name": v.Name, "value": v.Value})
+	}
+	row = map[string]bigquery.Value{
+		"timestamp":    s.Timestamp,
+		"measurements": measurements,
+		"categories": map[string]bigquery.Value{
+			"TEOrgnum": s.Categories.TEOrgnum,
+			"other":    categories,
+		},
+		"sum": s.Sum,
+	}
+	return row, "", nil
+}
+
+// CategoryOrgnum is the category holding the organization number of the
+// service owner.
+const CategoryOrgnum = "TE-orgnum"
+
+// Categories holds the category values of a Statistikk. The service owner is
+// kept in TEOrgnum, any other category returned by the API, for instance
+// when grouping by service provider, is kept in Other so that it survives
+// the trip to BigQuery.
+type Categories struct {
+	TEOrgnum string     `json:"TE-orgnum"`
+	Other    []Category `json:"-" bigquery:"other"`
+}
+
+// Category is a single category value.
+type Category struct {
+	Name  string `bigquery:"name"`
+	Value string `bigquery:"value"`
+}
+
+// Get returns the value of the named category or an empty string.
+func (c Categories) Get(name string) string {
+	if name == CategoryOrgnum {
+		return c.TEOrgnum
+	}
+	for _, v := range c.Other {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces or adds the named category.
+func (c *Categories) Set(name string, value string) {
+	if name == CategoryOrgnum {
+		c.TEOrgnum = value
+		return
+	}
+	other := make([]Category, 0, len(c.Other)+1)
+	for _, v := range c.Other {
+		if v.Name != name {
+			other = append(other, v)
+		}
+	}
+	other = append(other, Category{Name: name, Value: value})
+	sort.Slice(other, func(i, j int) bool {
+		return other[i].Name < other[j].Name
 	})
+	c.Other = other
+}
+
+// UnmarshalJSON reads an object of category names and values. Values which
+// are not strings are kept in their JSON form.
+func (c *Categories) UnmarshalJSON(b []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*c = Categories{}
+	for name, v := range raw {
+		var value string
+		if err := json.Unmarshal(v, &value); err != nil {
+			value = string(v)
+		}
+		c.Set(name, value)
+	}
+	return nil
+}
+
+// MarshalJSON writes the categories as a single object, the same shape as
+// the API.
+func (c Categories) MarshalJSON() ([]byte, error) {
+	m := make(map[string]string, len(c.Other)+1)
+	if c.TEOrgnum != "" {
+		m[CategoryOrgnum] = c.TEOrgnum
+	}
+	for _, v := range c.Other {
+		m[v.Name] = v.Value
+	}
+	return json.Marshal(m)
+}
+
+// ToMetrics splits a single Statistikk structure to one metric for each
+// method with InMetrics, so the metrics of an hour add up to Sum. Known
+// methods come first in the order of Methods, followed by unknown methods
+// sorted by name.
+func (s Statistikk) ToMetrics() (metrics []Metric) {
+	metrics = make([]Metric, 0)
+	for _, m := range Methods {
+		if !m.InMetrics {
+			continue
+		}
+		metrics = append(metrics, Metric{
+			Timestamp: s.Timestamp,
+			Metode:    m.Metode,
+			Antall:    s.Measurements[m.Key],
+			Lokaltid:  LocalTime(s.Timestamp),
+		})
+	}
+	// Methods added after this package was written.
+	for _, k := range s.Measurements.Unknown() {
+		metrics = append(metrics, Metric{
+			Timestamp: s.Timestamp,
+			Metode:    methodFor(k).Metode,
+			Antall:    s.Measurements[k],
+			Lokaltid:  LocalTime(s.Timestamp),
+		})
+	}
 	return
 }
 
 // Add two columns statistics objects.
 func (a Statistikk) Add(b Statistikk) (c Statistikk) {
 	c = a
-	c.Measurements.MinIDPassport += b.Measurements.MinIDPassport
-	c.Measurements.Commfides += b.Measurements.Commfides
-	c.Measurements.BuypassPassport += b.Measurements.BuypassPassport
-	c.Measurements.EIDAS += b.Measurements.EIDAS
-	c.Measurements.MinID += b.Measurements.MinID
-	c.Measurements.BankIDMobil += b.Measurements.BankIDMobil
-	c.Measurements.MinIDOTC += b.Measurements.MinIDOTC
-	c.Measurements.Antall += b.Measurements.Antall
-	c.Measurements.BuyPass += b.Measurements.BuyPass
-	c.Measurements.MinIDPIN += b.Measurements.MinIDPIN
-	c.Measurements.Federated += b.Measurements.Federated
-	c.Measurements.BankID += b.Measurements.BankID
+	c.Measurements = a.Measurements.Plus(b.Measurements)
 	if c.Timestamp.Year() < 1000 {
 		log.Fatal("Feiled date ", c)
 	}
@@ -121,42 +218,120 @@ func (a Statistikk) Add(b Statistikk) (c Statistikk) {
 }
 
 // CalcSum calculate the sum f all authentication methods, ignoring
-// federated numbers.
+// federated numbers and the total. The methods counted are those with InSum
+// in Methods, and any method unknown to this package.
 func (a Statistikk) CalcSum() (b Statistikk) {
 	b = a
-	tmp := a.Measurements
-	b.Sum = tmp.MinIDPassport +
-		tmp.Commfides +
-		tmp.BuypassPassport +
-		tmp.EIDAS +
-		tmp.MinID +
-		tmp.BankIDMobil +
-		tmp.MinIDOTC +
-		tmp.MinIDOTC +
-		tmp.BuyPass +
-		tmp.MinIDPIN +
-		tmp.BankID
+	b.Sum = a.Measurements.Sum()
 	if b.Timestamp.Year() < 1000 {
 		log.Fatal("Feiled date ", b)
 	}
 	return b
 }
 
-const (
-	MinIDPassport   = "MinIDPassport"
-	Commfides       = "Commfides"
-	BuypassPassport = "BuypassPassport"
-	EIDAS           = "EIDAS"
-	MinID           = "MinID"
-	BankIDMobil     = "BankIDMobil"
-	MinIDOTC        = "MinIDOTC"
-	Buypass         = "Buypass"
-	MinIDPIN        = "MinIDPIN"
-	BankID          = "BankID"
-)
-
+// Metric is a single method at a single point of measurement, a row in the
+// long BigQuery table. Grouped metrics, see ToGroupedMetrics, hold a group
+// in Metode and the name of the grouping in Gruppering. Gruppering decides
+// how Metode is read: if it is set, Metode is the name of a group, which
+// may look like a method, as "BankID", or not, as "high", and is neither
+// parsed as a Metode nor shown with the labels of the methods. Lokaltid is
+// Timestamp on the wall clock in Oslo, for reports by Norwegian days. Org is
+// set in the breakdown by organization, see OrgMetrics.
 type Metric struct {
-	Timestamp time.Time `bigquery:"timestamp"`
-	Metode    string    `bigquery:"metode"`
-	Antall    int       `bigquery:"antall"`
+	Timestamp  time.Time      `bigquery:"timestamp"`
+	Metode     Metode         `bigquery:"metode"`
+	Antall     int            `bigquery:"antall"`
+	Gruppering string         `bigquery:"gruppering"`
+	Lokaltid   civil.DateTime `bigquery:"lokaltid"`
+	Org        Org            `bigquery:"orgnr"`
+}
+
+// MetricSchema returns the schema of the long BigQuery tables. Columns
+// added after the first version are nullable so that rows without them can
+// still be streamed into older tables.
+func MetricSchema() bigquery.Schema {
+	return bigquery.Schema{
+		{Name: "timestamp", Type: bigquery.TimestampFieldType, Required: true},
+		{Name: "metode", Type: bigquery.StringFieldType, Required: true},
+		{Name: "antall", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "gruppering", Type: bigquery.StringFieldType},
+		{Name: "lokaltid", Type: bigquery.DateTimeFieldType},
+		{Name: "orgnr", Type: bigquery.StringFieldType},
+	}
+}
+
+// Save implements bigquery.ValueSaver. Empty nullable columns are left out.
+func (m Metric) Save() (row map[string]bigquery.Value, insertID string, err error) {
+	row = map[string]bigquery.Value{
+		"timestamp": m.Timestamp,
+		"metode":    string(m.Metode),
+		"antall":    m.Antall,
+	}
+	if m.Gruppering != "" {
+		row["gruppering"] = m.Gruppering
+	}
+	if m.Lokaltid.IsValid() {
+		row["lokaltid"] = m.Lokaltid
+	}
+	if m.Org != "" {
+		row["orgnr"] = string(m.Org)
+	}
+	return row, "", nil
+}
+
+// DisplayName returns a human readable name of the method or, in a grouped
+// metric, of the group.
+func (m Metric) DisplayName(lang Language) string {
+	if m.Gruppering == "" {
+		return m.Metode.DisplayName(lang)
+	}
+	if g, ok := LookupGrouping(m.Gruppering); ok && g.Label != nil {
+		return g.Label(string(m.Metode), lang)
+	}
+	return string(m.Metode)
+}
+
+// Load implements bigquery.ValueLoader. Columns which are not part of
+// Metric are ignored.
+func (m *Metric) Load(row []bigquery.Value, schema bigquery.Schema) error {
+	*m = Metric{}
+	var metode string
+	for i, f := range schema {
+		if row[i] == nil {
+			continue
+		}
+		var ok bool
+		switch f.Name {
+		case "timestamp":
+			m.Timestamp, ok = row[i].(time.Time)
+		case "metode":
+			metode, ok = row[i].(string)
+		case "antall":
+			var n int64
+			n, ok = row[i].(int64)
+			m.Antall = int(n)
+		case "gruppering":
+			m.Gruppering, ok = row[i].(string)
+		case "lokaltid":
+			m.Lokaltid, ok = row[i].(civil.DateTime)
+		case "orgnr":
+			var s string
+			s, ok = row[i].(string)
+			m.Org = Org(s)
+		default:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("idharvest: unexpected value %v for %v", row[i], f.Name)
+		}
+	}
+	// Groups are kept as they are, see Metric.
+	if m.Gruppering != "" {
+		m.Metode = Metode(metode)
+		return nil
+	}
+	if metode != "" {
+		return m.Metode.UnmarshalText([]byte(metode))
+	}
+	return nil
 }
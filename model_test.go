@@ -0,0 +1,192 @@
+package idharvest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestCategoriesJSON(t *testing.T) {
+	var c Categories
+	err := json.Unmarshal([]byte(`{"TE-orgnum":"889640782","TL-orgnum":"974760673","client":42}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Categories{
+		TEOrgnum: "889640782",
+		Other: []Category{
+			{Name: "TL-orgnum", Value: "974760673"},
+			{Name: "client", Value: "42"},
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Unmarshal = %v, want %v", c, want)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Categories
+	if err := json.Unmarshal(b, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("Round trip = %v, want %v", again, want)
+	}
+}
+
+func TestCategoriesSet(t *testing.T) {
+	var c Categories
+	c.Set("b", "1")
+	c.Set("a", "2")
+	c.Set("b", "3")
+	c.Set(CategoryOrgnum, string(OldOrg))
+	if c.Get("b") != "3" || c.Get("a") != "2" || c.Get(CategoryOrgnum) != string(OldOrg) {
+		t.Error("Incorrect categories: ", c)
+	}
+	if len(c.Other) != 2 || c.Other[0].Name != "a" {
+		t.Error("Other should be sorted and without duplicates: ", c.Other)
+	}
+}
+
+// TestInferSchema makes sure the BigQuery schemas can be created and that
+// the rows saved match them.
+func TestInferSchema(t *testing.T) {
+	schema, err := StatistikkSchema()
+	if err != nil {
+		t.Fatal("Statistikk: ", err)
+	}
+	s := Statistikk{Measurements: Measurements{KeyBankID: 1, "Foo ID": 2}}
+	row, _, err := s.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRow(t, schema, row)
+	for _, m := range append(s.ToMetrics(), s.ToGroupedMetrics(Groupings...)...) {
+		row, _, err := m.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRow(t, MetricSchema(), row)
+	}
+}
+
+// checkRow fails if row has columns which are not in schema, or lacks
+// required columns.
+func checkRow(t *testing.T, schema bigquery.Schema, row map[string]bigquery.Value) {
+	t.Helper()
+	columns := make(map[string]bool)
+	for _, f := range schema {
+		columns[f.Name] = true
+		v, ok := row[f.Name]
+		if !ok {
+			if f.Required {
+				t.Error("Missing column ", f.Name)
+			}
+			continue
+		}
+		if f.Type != bigquery.RecordFieldType {
+			continue
+		}
+		if f.Repeated {
+			for _, elem := range v.([]bigquery.Value) {
+				checkRow(t, f.Schema, elem.(map[string]bigquery.Value))
+			}
+			continue
+		}
+		checkRow(t, f.Schema, v.(map[string]bigquery.Value))
+	}
+	for name := range row {
+		if !columns[name] {
+			t.Error("Column not in schema ", name)
+		}
+	}
+}
+
+func TestMeasurementsUnknown(t *testing.T) {
+	var stat []Statistikk
+	err := json.Unmarshal([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1,"BankID":2,"Antall":11,"Federated":3,"Foo ID":5}}]`), &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stat[0].CalcSum()
+	if s.Sum != 8 {
+		t.Error("New methods should be counted in the sum, got ", s.Sum)
+	}
+	if got := s.Measurements.Unknown(); !reflect.DeepEqual(got, []string{"Foo ID"}) {
+		t.Error("Unknown() = ", got)
+	}
+	found := false
+	for _, m := range s.ToMetrics() {
+		if m.Metode == "FooID3c2bb410" && m.Antall == 5 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("New method missing from metrics: ", s.ToMetrics())
+	}
+
+	sum := s.Add(s)
+	if sum.Measurements["Foo ID"] != 10 || s.Measurements["Foo ID"] != 5 {
+		t.Error("Add should sum every method without changing its operands: ", sum, s)
+	}
+}
+
+func TestMethodsRegistry(t *testing.T) {
+	keys, columns, metoder := map[string]bool{}, map[string]bool{}, map[Metode]bool{}
+	for _, m := range Methods {
+		if m.Key == "" || m.Column == "" || m.Metode == "" || m.Label == "" || m.EnglishLabel == "" {
+			t.Error("Incomplete method: ", m)
+		}
+		if keys[m.Key] || columns[m.Column] || metoder[m.Metode] {
+			t.Error("Duplicate method: ", m)
+		}
+		keys[m.Key], columns[m.Column], metoder[m.Metode] = true, true, true
+	}
+}
+
+// TestTotalsNotInMetrics checks the deliberate choice to leave Antall and
+// Federated out of both the sum and the metrics.
+func TestTotalsNotInMetrics(t *testing.T) {
+	for _, m := range Methods {
+		total := m.Key == KeyAntall || m.Key == KeyFederated
+		if m.InSum == total || m.InMetrics == total {
+			t.Error("Only Antall and Federated should be left out of the sum and the metrics, got ", m)
+		}
+		if m.InMetrics && !m.InSum {
+			t.Error("A method in the metrics must be in the sum, got ", m)
+		}
+	}
+	s := Statistikk{Measurements: Measurements{KeyAntall: 3, KeyFederated: 2, KeyMinID: 1}}
+	for _, metric := range s.ToMetrics() {
+		if metric.Metode == Antall || metric.Metode == Federated {
+			t.Error("ToMetrics() should leave out ", metric.Metode)
+		}
+	}
+}
+
+// TestMetricsAddUpToSum checks that reshaping, summation and addition agree.
+func TestMetricsAddUpToSum(t *testing.T) {
+	m := Measurements{"Foo ID": 1}
+	for i, method := range Methods {
+		m[method.Key] = i + 1
+	}
+	s := Statistikk{Timestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), Measurements: m}.CalcSum()
+	// Everything except Antall (8) and Federated (11).
+	if want := 78 - 8 - 11 + 1; s.Sum != want {
+		t.Errorf("Sum = %v, want %v", s.Sum, want)
+	}
+	total := 0
+	for _, metric := range s.ToMetrics() {
+		total += metric.Antall
+	}
+	if total != s.Sum {
+		t.Errorf("Metrics add up to %v, Sum is %v", total, s.Sum)
+	}
+	if double := s.Add(s).CalcSum(); double.Sum != 2*s.Sum {
+		t.Errorf("Sum of Add = %v, want %v", double.Sum, 2*s.Sum)
+	}
+}
@@ -0,0 +1,250 @@
+package idharvest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetParallelism is the number of goroutines encoding a Parquet file.
+const parquetParallelism = 4
+
+// Timestamps are stored as microseconds in UTC, like TIMESTAMP in BigQuery.
+// Lokaltid in parquetMetric is the wall clock in Oslo, not adjusted to UTC.
+const (
+	parquetTimestamp = "type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MICROS"
+	parquetString    = "type=BYTE_ARRAY, convertedtype=UTF8"
+)
+
+type parquetField struct {
+	Tag    string
+	Fields []parquetField `json:",omitempty"`
+}
+
+// StatistikkParquetSchema returns the wide Parquet schema of Statistikk in
+// the JSON format of parquet-go: the timestamp, the organization number, a
+// column for each method in Methods named like in StatistikkSchema, the
+// unknown methods in the map other, and the sum.
+func StatistikkParquetSchema() string {
+	fields := []parquetField{
+		{Tag: "name=timestamp, " + parquetTimestamp + ", repetitiontype=REQUIRED"},
+		{Tag: "name=orgnr, " + parquetString + ", repetitiontype=OPTIONAL"},
+	}
+	for _, v := range Methods {
+		fields = append(fields, parquetField{Tag: "name=" + v.Column + ", type=INT64, repetitiontype=REQUIRED"})
+	}
+	fields = append(fields,
+		parquetField{
+			Tag: "name=other, type=MAP, repetitiontype=OPTIONAL",
+			Fields: []parquetField{
+				{Tag: "name=key, " + parquetString + ", repetitiontype=REQUIRED"},
+				{Tag: "name=value, type=INT64, repetitiontype=REQUIRED"},
+			},
+		},
+		parquetField{Tag: "name=sum, type=INT64, repetitiontype=REQUIRED"},
+	)
+	b, _ := json.Marshal(parquetField{Tag: "name=statistikk, repetitiontype=REQUIRED", Fields: fields})
+	return string(b)
+}
+
+// parquetMetric is a row of the long Parquet schema of Metric.
+type parquetMetric struct {
+	Timestamp  int64   `parquet:"name=timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MICROS"`
+	Metode     string  `parquet:"name=metode, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Antall     int64   `parquet:"name=antall, type=INT64"`
+	Gruppering *string `parquet:"name=gruppering, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Lokaltid   *int64  `parquet:"name=lokaltid, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=false, logicaltype.unit=MICROS, repetitiontype=OPTIONAL"`
+	Orgnr      *string `parquet:"name=orgnr, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// WriteStatistikkParquet writes stat to w as a Parquet file with
+// StatistikkParquetSchema. The file has min and max statistics for each
+// column and is compressed with Snappy.
+func WriteStatistikkParquet(w io.Writer, stat []Statistikk) error {
+	pw, err := writer.NewJSONWriterFromWriter(StatistikkParquetSchema(), w, parquetParallelism)
+	if err != nil {
+		return err
+	}
+	for _, s := range stat {
+		row := map[string]interface{}{
+			"timestamp": micros(s.Timestamp),
+			"sum":       s.Sum,
+		}
+		if s.Categories.TEOrgnum != "" {
+			row["orgnr"] = s.Categories.TEOrgnum
+		}
+		for _, v := range Methods {
+			row[v.Column] = s.Measurements[v.Key]
+		}
+		if unknown := s.Measurements.Unknown(); len(unknown) > 0 {
+			other := make(map[string]int, len(unknown))
+			for _, k := range unknown {
+				other[k] = s.Measurements[k]
+			}
+			row["other"] = other
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(string(b)); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
+
+// WriteMetricsParquet writes metrics to w as a Parquet file with the columns
+// of MetricSchema, with column statistics and Snappy compression like
+// WriteStatistikkParquet.
+func WriteMetricsParquet(w io.Writer, metrics []Metric) error {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetMetric), parquetParallelism)
+	if err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		row := &parquetMetric{
+			Timestamp:  micros(m.Timestamp),
+			Metode:     string(m.Metode),
+			Antall:     int64(m.Antall),
+			Gruppering: optional(m.Gruppering),
+			Orgnr:      optional(string(m.Org)),
+		}
+		if m.Lokaltid.IsValid() {
+			local := micros(m.Lokaltid.In(time.UTC))
+			row.Lokaltid = &local
+		}
+		if err := pw.Write(row); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
+
+// ParquetSink writes the tables of a run to Parquet files in Dir,
+// partitioned by the year in Oslo in the directory layout of Hive:
+//
+//	nav/year=2013/nav.parquet
+//	navmetrics/year=2013/navmetrics.parquet
+//
+// Like CSVSink the rows are kept in memory until Flush, which replaces the
+// tables, so it is meant for Harvester.Rebuild. The files are written to a
+// temporary directory next to Dir first, and a table is only replaced once
+// all files are written, so a failed run leaves the earlier files as they
+// were.
+type ParquetSink struct {
+	*MemorySink
+	Dir string
+}
+
+// NewParquetSink returns a sink writing to dir.
+func NewParquetSink(dir string) *ParquetSink {
+	return &ParquetSink{MemorySink: NewMemorySink(), Dir: dir}
+}
+
+// Flush implements Sink by writing the files.
+func (s *ParquetSink) Flush(ctx context.Context) error {
+	dir := filepath.Clean(s.Dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	years := make(map[int]Series)
+	for _, v := range s.Series() {
+		y := LocalTime(v.Timestamp).Date.Year
+		years[y] = append(years[y], v)
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, tableName), 0755); err != nil {
+		return err
+	}
+	for y, series := range years {
+		err := writePartition(ctx, tmp, tableName, y, func(w io.Writer) error {
+			return WriteStatistikkParquet(w, series)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	tables := []string{MetricsTableName, GroupedMetricsTableName, OrgMetricsTableName}
+	for _, table := range tables {
+		years := make(map[int][]Metric)
+		for _, m := range s.Metrics(table) {
+			y := LocalTime(m.Timestamp).Date.Year
+			years[y] = append(years[y], m)
+		}
+		if err := os.MkdirAll(filepath.Join(tmp, table), 0755); err != nil {
+			return err
+		}
+		for y, metrics := range years {
+			err := writePartition(ctx, tmp, table, y, func(w io.Writer) error {
+				return WriteMetricsParquet(w, metrics)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range append([]string{tableName}, tables...) {
+		if err := replaceTable(dir, tmp, table); err != nil {
+			return err
+		}
+	}
+	return s.MemorySink.Flush(ctx)
+}
+
+// writePartition writes the file of table for year in dir with write,
+// unless ctx is done.
+func writePartition(ctx context.Context, dir string, table string, year int, write func(io.Writer) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, table, fmt.Sprintf("year=%d", year), table+".parquet")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return f.Close()
+}
+
+// replaceTable moves the directory of table from tmp to dir, moving the
+// earlier one to tmp to be removed with it.
+func replaceTable(dir string, tmp string, table string) error {
+	path := filepath.Join(dir, table)
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, filepath.Join(tmp, table+".old")); err != nil {
+			return err
+		}
+	}
+	return os.Rename(filepath.Join(tmp, table), path)
+}
@@ -0,0 +1,219 @@
+package idharvest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// readParquet opens the Parquet file in b, reading rows into obj.
+func readParquet(t *testing.T, b []byte, obj interface{}) *reader.ParquetReader {
+	t.Helper()
+	f, err := buffer.NewBufferFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(f, obj, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr
+}
+
+// parquetColumn returns the schema element and the metadata of the first
+// column chunk named name. The reader renames the columns to Go names, like
+// Timestamp for timestamp, so names are compared ignoring case.
+func parquetColumn(t *testing.T, pr *reader.ParquetReader, name string) (*parquet.SchemaElement, *parquet.ColumnMetaData) {
+	t.Helper()
+	var element *parquet.SchemaElement
+	for _, e := range pr.Footer.Schema {
+		if strings.EqualFold(e.Name, name) {
+			element = e
+		}
+	}
+	for _, c := range pr.Footer.RowGroups[0].Columns {
+		if strings.EqualFold(c.MetaData.PathInSchema[len(c.MetaData.PathInSchema)-1], name) && element != nil {
+			return element, c.MetaData
+		}
+	}
+	t.Fatal("No column ", name)
+	return nil, nil
+}
+
+func int64Stat(b []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func TestWriteStatistikkParquet(t *testing.T) {
+	s := hours(OrgNr, 3, 0, 1, 2)
+	s[2].Measurements["Nytt"] = 2
+	for i := range s {
+		s[i] = s[i].CalcSum()
+	}
+	var b bytes.Buffer
+	if err := WriteStatistikkParquet(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	pr := readParquet(t, b.Bytes(), nil)
+	defer pr.ReadStop()
+	if pr.GetNumRows() != 3 {
+		t.Fatal("WriteStatistikkParquet() should write 3 rows, got ", pr.GetNumRows())
+	}
+	for _, v := range Methods {
+		parquetColumn(t, pr, v.Column)
+	}
+	e, md := parquetColumn(t, pr, "timestamp")
+	if e.LogicalType == nil || !e.LogicalType.IsSetTIMESTAMP() || !e.LogicalType.TIMESTAMP.IsAdjustedToUTC || !e.LogicalType.TIMESTAMP.Unit.IsSetMICROS() {
+		t.Error("timestamp should be a TIMESTAMP in microseconds adjusted to UTC, got ", e.LogicalType)
+	}
+	if int64Stat(md.Statistics.MinValue) != micros(s[0].Timestamp) || int64Stat(md.Statistics.MaxValue) != micros(s[2].Timestamp) {
+		t.Error("The statistics of timestamp should be the first and the last hour, got ", md.Statistics)
+	}
+	if _, md := parquetColumn(t, pr, "sum"); int64Stat(md.Statistics.MaxValue) != 5 {
+		t.Error("The max of sum should include the unknown method, got ", int64Stat(md.Statistics.MaxValue))
+	}
+}
+
+func TestWriteMetricsParquet(t *testing.T) {
+	ts := time.Date(2020, 5, 1, 22, 0, 0, 0, time.UTC)
+	metrics := []Metric{
+		{Timestamp: ts, Metode: BankIDMobil, Antall: 7, Gruppering: "Metode", Lokaltid: LocalTime(ts), Org: OrgNr},
+		{Timestamp: ts.Add(time.Hour), Metode: MinID, Antall: 1},
+	}
+	var b bytes.Buffer
+	if err := WriteMetricsParquet(&b, metrics); err != nil {
+		t.Fatal(err)
+	}
+	pr := readParquet(t, b.Bytes(), new(parquetMetric))
+	defer pr.ReadStop()
+	rows := make([]parquetMetric, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	gruppering, org := "Metode", string(OrgNr)
+	local := micros(time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC))
+	want := []parquetMetric{
+		{Timestamp: micros(ts), Metode: string(BankIDMobil), Antall: 7, Gruppering: &gruppering, Lokaltid: &local, Orgnr: &org},
+		{Timestamp: micros(ts.Add(time.Hour)), Metode: string(MinID), Antall: 1},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Error("WriteMetricsParquet() got ", rows, " want ", want)
+	}
+	e, _ := parquetColumn(t, pr, "lokaltid")
+	if e.LogicalType == nil || !e.LogicalType.IsSetTIMESTAMP() || e.LogicalType.TIMESTAMP.IsAdjustedToUTC {
+		t.Error("lokaltid should be a TIMESTAMP not adjusted to UTC, got ", e.LogicalType)
+	}
+	if _, md := parquetColumn(t, pr, "antall"); int64Stat(md.Statistics.MinValue) != 1 || int64Stat(md.Statistics.MaxValue) != 7 {
+		t.Error("The statistics of antall should be 1 and 7, got ", md.Statistics)
+	}
+}
+
+func TestParquetSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idharvest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 23 UTC on New Year's Eve is in the next year in Oslo.
+	var s Series
+	for _, ts := range []time.Time{
+		time.Date(2019, 12, 31, 22, 0, 0, 0, time.UTC),
+		time.Date(2019, 12, 31, 23, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		v := Statistikk{Timestamp: ts, Measurements: Measurements{KeyMinID: 1}, Sum: 1}
+		v.Categories.TEOrgnum = string(OrgNr)
+		s = append(s, v)
+	}
+	ctx := context.Background()
+	sink := NewParquetSink(dir)
+	// A stale partition from an earlier run.
+	if err := os.MkdirAll(filepath.Join(dir, tableName, "year=2000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteSeries(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteMetrics(ctx, MetricsTableName, s.Metrics()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for table, want := range map[string]map[string]int64{
+		tableName:               {"year=2019": 1, "year=2020": 2},
+		MetricsTableName:        {"year=2019": int64(len(s[:1].Metrics())), "year=2020": int64(len(s[1:].Metrics()))},
+		GroupedMetricsTableName: {},
+	} {
+		names, _ := filepath.Glob(filepath.Join(dir, table, "year=*"))
+		got := make(map[string]int64)
+		for _, name := range names {
+			b, err := ioutil.ReadFile(filepath.Join(name, table+".parquet"))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			pr := readParquet(t, b, nil)
+			got[filepath.Base(name)] = pr.GetNumRows()
+			pr.ReadStop()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Error(table, " should have the rows ", want, ", got ", got)
+		}
+	}
+}
+
+func TestParquetSinkKeepsArchiveOnFailure(t *testing.T) {
+	base, err := ioutil.TempDir("", "idharvest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	dir := filepath.Join(base, "archive")
+
+	ctx := context.Background()
+	sink := NewParquetSink(dir)
+	s := hours(OrgNr, 1, 0, 1, 2)
+	if err := sink.WriteSeries(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, tableName, "year=2020", tableName+".parquet")
+	before, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run cancelled before the files are written.
+	if err := sink.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteSeries(ctx, hours(OrgNr, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := sink.Flush(cancelled); err != context.Canceled {
+		t.Error("Flush() with a cancelled context should fail, got ", err)
+	}
+	if after, err := ioutil.ReadFile(file); err != nil || !bytes.Equal(after, before) {
+		t.Error("A failed Flush() should keep the earlier files, got ", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(base, ".archive-*")); len(tmp) != 0 {
+		t.Error("A failed Flush() should remove its temporary files, got ", tmp)
+	}
+}
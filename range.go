@@ -0,0 +1,151 @@
+package idharvest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWindowMonths is the length of the periods QueryRange asks the API
+// for. Longer periods of hourly data are not returned reliably.
+const DefaultWindowMonths = 5
+
+// window is a period requested in a single call to the API. Both From and
+// To are included, like the API's from and to.
+type window struct {
+	From time.Time
+	To   time.Time
+}
+
+// period returns the period g sums over, in UTC like the timestamps of the
+// API.
+func (g Granularity) period() Period {
+	switch g {
+	case Days, SumDays:
+		return PeriodDay
+	case Months, SumMonths:
+		return PeriodMonth
+	case Years, SumYears:
+		return PeriodYear
+	}
+	return PeriodHour
+}
+
+// splitWindows divides the period from - to into windows of about months
+// months for a query with granularity g. Every window but the first starts
+// at the start of a period of g and ends the hour before the next one, so
+// that each period is read whole by a single call, and no hour is read
+// twice. A window is never shorter than a period.
+func splitWindows(from time.Time, to time.Time, months int, g Granularity) []window {
+	if months <= 0 {
+		months = DefaultWindowMonths
+	}
+	p := g.period()
+	windows := make([]window, 0)
+	for aDate := from; aDate.Before(to); {
+		next := p.Truncate(aDate.UTC().AddDate(0, months, 0))
+		if !next.After(aDate) {
+			next = p.Truncate(aDate.UTC().AddDate(1, 0, 0))
+		}
+		if !next.Before(to) {
+			windows = append(windows, window{From: aDate, To: to})
+			break
+		}
+		windows = append(windows, window{From: aDate, To: next.Add(-time.Hour)})
+		aDate = next
+	}
+	return windows
+}
+
+// QueryRange is like Fetch but accepts any period. The period is split into
+// windows of c.WindowMonths which are read by c.Workers workers, and the
+// results are returned as one series ordered by timestamp, the same as a
+// single call to Fetch would return.
+func (c *Client) QueryRange(ctx context.Context, opts QueryOptions) (stat []Statistikk, err error) {
+	series, err := c.QueryRanges(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return series[0], nil
+}
+
+// QueryRanges is like QueryRange for several queries at once, for instance
+// one for each organization number. All windows of all queries share the
+// same pool of c.Workers workers and c.Limiter. The series are returned in
+// the order of the queries. The first error stops the remaining work.
+func (c *Client) QueryRanges(ctx context.Context, queries ...QueryOptions) (series [][]Statistikk, err error) {
+	type job struct {
+		query int
+		opts  QueryOptions
+	}
+	jobs := make([]job, 0)
+	for i, opts := range queries {
+		for _, w := range splitWindows(opts.From, opts.To, c.WindowMonths, opts.Granularity) {
+			windowOpts := opts
+			windowOpts.From, windowOpts.To = w.From, w.To
+			jobs = append(jobs, job{query: i, opts: windowOpts})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([][]Statistikk, len(jobs))
+	errs := make(chan error, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < c.workers(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				tmp, err := c.Fetch(ctx, jobs[i].opts)
+				if err != nil {
+					errs <- err
+					cancel()
+					continue
+				}
+				results[i] = tmp
+			}
+		}()
+	}
+	for i := range jobs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(next)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	series = make([][]Statistikk, len(queries))
+	for i := range series {
+		series[i] = make([]Statistikk, 0)
+	}
+	for i, j := range jobs {
+		series[j.query] = append(series[j.query], results[i]...)
+	}
+	for i := range series {
+		Series(series[i]).Sort()
+	}
+	return series, nil
+}
+
+// QueryRange is a shorthand for DefaultClient.QueryRange.
+func QueryRange(ctx context.Context, opts QueryOptions) (stat []Statistikk, err error) {
+	return DefaultClient.QueryRange(ctx, opts)
+}
+
+// QueryRanges is a shorthand for DefaultClient.QueryRanges.
+func QueryRanges(ctx context.Context, queries ...QueryOptions) (series [][]Statistikk, err error) {
+	return DefaultClient.QueryRanges(ctx, queries...)
+}
@@ -0,0 +1,195 @@
+package idharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSplitWindows(t *testing.T) {
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mid := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	tests := []struct {
+		name   string
+		from   time.Time
+		to     time.Time
+		months int
+		g      Granularity
+		want   []window
+	}{
+		{
+			name: "Empty period",
+			from: jan,
+			to:   jan,
+			want: []window{},
+		},
+		{
+			name:   "Shorter than a window",
+			from:   jan,
+			to:     jan.AddDate(0, 0, 3),
+			months: 5,
+			want:   []window{{jan, jan.AddDate(0, 0, 3)}},
+		},
+		{
+			name:   "Last window is clipped",
+			from:   jan,
+			to:     jan.AddDate(0, 7, 0),
+			months: 5,
+			want:   []window{{jan, jan.AddDate(0, 5, 0).Add(-hour)}, {jan.AddDate(0, 5, 0), jan.AddDate(0, 7, 0)}},
+		},
+		{
+			name: "Default window",
+			from: jan,
+			to:   jan.AddDate(1, 0, 0),
+			want: []window{{jan, jan.AddDate(0, 5, 0).Add(-hour)}, {jan.AddDate(0, 5, 0), jan.AddDate(0, 10, 0).Add(-hour)}, {jan.AddDate(0, 10, 0), jan.AddDate(1, 0, 0)}},
+		},
+		{
+			name:   "Days start at midnight",
+			from:   mid,
+			to:     mid.AddDate(0, 2, 0),
+			months: 1,
+			g:      SumDays,
+			want:   []window{{mid, jan.AddDate(0, 1, 14).Add(-hour)}, {jan.AddDate(0, 1, 14), jan.AddDate(0, 2, 14).Add(-hour)}, {jan.AddDate(0, 2, 14), mid.AddDate(0, 2, 0)}},
+		},
+		{
+			name:   "Months start on the first",
+			from:   mid,
+			to:     mid.AddDate(0, 2, 0),
+			months: 1,
+			g:      SumMonths,
+			want:   []window{{mid, jan.AddDate(0, 1, 0).Add(-hour)}, {jan.AddDate(0, 1, 0), jan.AddDate(0, 2, 0).Add(-hour)}, {jan.AddDate(0, 2, 0), mid.AddDate(0, 2, 0)}},
+		},
+		{
+			name:   "Years are longer than a window",
+			from:   mid,
+			to:     mid.AddDate(1, 0, 0),
+			months: 5,
+			g:      Years,
+			want:   []window{{mid, jan.AddDate(1, 0, 0).Add(-hour)}, {jan.AddDate(1, 0, 0), mid.AddDate(1, 0, 0)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitWindows(tt.from, tt.to, tt.months, tt.g); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// hourlyHandler answers with one Statistikk for every hour from and
+// including from to and including to, like the API does.
+func hourlyHandler(t *testing.T, calls *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		from := StringToDate(r.URL.Query().Get("from"))
+		to := StringToDate(r.URL.Query().Get("to"))
+		stat := make([]Statistikk, 0)
+		for ts := from; !ts.After(to); ts = ts.Add(time.Hour) {
+			var s Statistikk
+			s.Timestamp = ts
+			s.Measurements = Measurements{KeyMinID: 1}
+			s.Categories.TEOrgnum = string(OrgNr)
+			stat = append(stat, s)
+		}
+		if err := json.NewEncoder(w).Encode(stat); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(hourlyHandler(t, &calls))
+	defer srv.Close()
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	c := &Client{BaseURL: srv.URL, WindowMonths: 1}
+	stat, err := c.QueryRange(context.Background(), QueryOptions{
+		From:       from,
+		To:         to.AddDate(0, 2, 0),
+		Categories: map[string]string{CategoryOrgnum: string(OrgNr)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 3 {
+		t.Error("Expected 3 calls, got ", calls)
+	}
+	wantHours := int(to.AddDate(0, 2, 0).Sub(from)/time.Hour) + 1
+	if len(stat) != wantHours {
+		t.Errorf("Got %v hours, want %v", len(stat), wantHours)
+	}
+	for i := 1; i < len(stat); i++ {
+		if !stat[i].Timestamp.After(stat[i-1].Timestamp) {
+			t.Fatal("Series is not ordered or contains duplicates at ", stat[i].Timestamp)
+		}
+	}
+}
+
+func TestQueryRanges(t *testing.T) {
+	var calls, active, maxActive int32
+	hourly := hourlyHandler(t, &calls)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		hourly(w, r)
+	}))
+	defer srv.Close()
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &Client{BaseURL: srv.URL, WindowMonths: 1, Workers: 3}
+	series, err := c.QueryRanges(context.Background(),
+		QueryOptions{From: from, To: from.AddDate(0, 6, 0)},
+		QueryOptions{From: from, To: from.AddDate(0, 2, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 8 {
+		t.Error("Expected 8 calls, got ", calls)
+	}
+	if maxActive < 2 || maxActive > 3 {
+		t.Error("Expected 2 or 3 parallel requests, got ", maxActive)
+	}
+	if len(series) != 2 {
+		t.Fatal("Expected two series, got ", len(series))
+	}
+	for i, to := range []time.Time{from.AddDate(0, 6, 0), from.AddDate(0, 2, 0)} {
+		if want := int(to.Sub(from)/time.Hour) + 1; len(series[i]) != want {
+			t.Errorf("Series %v has %v hours, want %v", i, len(series[i]), want)
+		}
+	}
+}
+
+func TestQueryRangesError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	from := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &Client{BaseURL: srv.URL, Workers: 2}
+	_, err := c.QueryRanges(context.Background(), QueryOptions{From: from, To: from.AddDate(10, 0, 0)})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if calls := atomic.LoadInt32(&calls); calls > 4 {
+		t.Error("Work should stop after the first error, got calls: ", calls)
+	}
+}
@@ -0,0 +1,146 @@
+package idharvest
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Period is the length of the buckets used when resampling.
+type Period int
+
+const (
+	PeriodHour Period = iota
+	PeriodDay
+	PeriodWeek // ISO week, starting on Monday.
+	PeriodMonth
+	PeriodQuarter
+	PeriodYear
+)
+
+func (p Period) String() string {
+	switch p {
+	case PeriodHour:
+		return "hour"
+	case PeriodDay:
+		return "day"
+	case PeriodWeek:
+		return "week"
+	case PeriodMonth:
+		return "month"
+	case PeriodQuarter:
+		return "quarter"
+	case PeriodYear:
+		return "year"
+	}
+	return "unknown"
+}
+
+// Truncate returns the start of the period containing t, in the location of
+// t.
+func (p Period) Truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	loc := t.Location()
+	switch p {
+	case PeriodHour:
+		// On the instant rather than the wall clock, which shows 02:00
+		// twice when summer time ends. Shifting by the offset keeps the
+		// hours of zones half an hour from UTC.
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		return t.Add(shift).Truncate(time.Hour).Add(-shift)
+	case PeriodDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case PeriodWeek:
+		// Weekday counts from Sunday, ISO weeks from Monday.
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, loc)
+	case PeriodMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case PeriodQuarter:
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, loc)
+	case PeriodYear:
+		return time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
+
+// Resample sums the values of s into one value per UTC period, see
+// ResampleIn.
+func (s Series) Resample(p Period) Series {
+	return s.ResampleIn(p, time.UTC)
+}
+
+// ResampleIn sums the values of s into one value per period in loc, stamped
+// with the start of the period in UTC and with Sum recalculated. In Oslo a
+// day is 23 or 25 hours when daylight saving time starts or ends. Values
+// with different categories, for instance different organization numbers,
+// are kept apart. The result is ordered by timestamp.
+func (s Series) ResampleIn(p Period, loc *time.Location) Series {
+	type key struct {
+		unix       int64
+		categories string
+	}
+	index := make(map[key]int)
+	resampled := make(Series, 0)
+	for _, v := range s {
+		start := p.Truncate(v.Timestamp.In(loc)).UTC()
+		b, _ := json.Marshal(v.Categories)
+		k := key{start.Unix(), string(b)}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(resampled)
+			v.Timestamp = start
+			v.Measurements = v.Measurements.Clone()
+			resampled = append(resampled, v.CalcSum())
+			continue
+		}
+		resampled[i] = resampled[i].Add(v).CalcSum()
+	}
+	resampled.Sort()
+	return resampled
+}
+
+// ResampleMetrics sums metrics into one metric per UTC period, see
+// ResampleMetricsIn.
+func ResampleMetrics(metrics []Metric, p Period) []Metric {
+	return ResampleMetricsIn(metrics, p, time.UTC)
+}
+
+// ResampleMetricsIn sums metrics into one metric per period in loc, method,
+// grouping and organization, stamped with the start of the period in UTC and in Lokaltid.
+// The result is ordered by timestamp, keeping the order of the methods
+// within a period.
+func ResampleMetricsIn(metrics []Metric, p Period, loc *time.Location) []Metric {
+	type key struct {
+		unix       int64
+		metode     Metode
+		gruppering string
+		org        Org
+	}
+	index := make(map[key]int)
+	resampled := make([]Metric, 0)
+	for _, m := range metrics {
+		start := p.Truncate(m.Timestamp.In(loc)).UTC()
+		k := key{start.Unix(), m.Metode, m.Gruppering, m.Org}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(resampled)
+			m.Timestamp = start
+			m.Lokaltid = LocalTime(start)
+			resampled = append(resampled, m)
+			continue
+		}
+		resampled[i].Antall += m.Antall
+	}
+	sortMetrics(resampled)
+	return resampled
+}
+
+// sortMetrics orders metrics by timestamp, keeping the order of metrics
+// with the same timestamp.
+func sortMetrics(metrics []Metric) {
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].Timestamp.Before(metrics[j].Timestamp)
+	})
+}
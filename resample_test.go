@@ -0,0 +1,214 @@
+package idharvest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeriodTruncate(t *testing.T) {
+	// Sunday 3 May 2020, in the ISO week starting Monday 27 April.
+	ts := time.Date(2020, 5, 3, 17, 42, 0, 0, time.UTC)
+	tests := []struct {
+		p    Period
+		want time.Time
+	}{
+		{PeriodHour, time.Date(2020, 5, 3, 17, 0, 0, 0, time.UTC)},
+		{PeriodDay, time.Date(2020, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeek, time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodQuarter, time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodYear, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := test.p.Truncate(ts); !got.Equal(test.want) {
+			t.Errorf("%v.Truncate(%v) = %v, want %v", test.p, ts, got, test.want)
+		}
+	}
+
+	// ISO week 1 of 2021 starts on Monday 4 January, so 3 January is in the
+	// last week of 2020.
+	monday := time.Date(2020, 12, 28, 0, 0, 0, 0, time.UTC)
+	if got := PeriodWeek.Truncate(time.Date(2021, 1, 3, 23, 0, 0, 0, time.UTC)); !got.Equal(monday) {
+		t.Error("Week across new year, got ", got)
+	}
+	if got := PeriodWeek.Truncate(monday); !got.Equal(monday) {
+		t.Error("A Monday is the start of its week, got ", got)
+	}
+	if got := PeriodQuarter.Truncate(time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Fourth quarter, got ", got)
+	}
+}
+
+func TestSeriesResample(t *testing.T) {
+	s := append(hours(OrgNr, 1, 0, 1, 23), hours(OldOrg, 5, 2)...)
+	next := Statistikk{
+		Timestamp:    time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		Measurements: Measurements{KeyMinID: 2, KeyBankID: 3},
+	}
+	next.Categories.TEOrgnum = string(OrgNr)
+	s = append(s, next)
+
+	got := s.Resample(PeriodDay)
+	if len(got) != 3 {
+		t.Fatal("Resample() should give one value per day and organization, got ", got)
+	}
+	if !got.IsSorted() {
+		t.Error("Resample() should be sorted, got ", got)
+	}
+	if got[0].Categories.TEOrgnum != string(OrgNr) || got[0].Measurements.MinID() != 3 || got[0].Sum != 3 {
+		t.Error("First day of OrgNr, got ", got[0])
+	}
+	if got[1].Categories.TEOrgnum != string(OldOrg) || got[1].Sum != 5 {
+		t.Error("First day of OldOrg, got ", got[1])
+	}
+	if !got[2].Timestamp.Equal(next.Timestamp) || got[2].Sum != 5 {
+		t.Error("Second day, got ", got[2])
+	}
+	if s[0].Measurements.MinID() != 1 || !s[0].Timestamp.Equal(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Resample() changed its input")
+	}
+
+	if got := s.Resample(PeriodMonth); len(got) != 2 || got[0].Sum != 8 {
+		t.Error("Resample(PeriodMonth), got ", got)
+	}
+}
+
+func TestResampleMetrics(t *testing.T) {
+	s := hours(OrgNr, 1, 0, 1, 2)
+	s[2].Measurements[KeyBankID] = 4
+	metrics := append(s.Metrics(), s.GroupedMetrics(ByFamily)...)
+
+	got := ResampleMetrics(metrics, PeriodDay)
+	sums := make(map[string]int)
+	for _, m := range got {
+		if !m.Timestamp.Equal(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Error("Metric should be stamped with the start of the day, got ", m)
+		}
+		sums[m.Gruppering+"/"+string(m.Metode)] += m.Antall
+	}
+	want := map[string]int{
+		"/MinID":         3,
+		"/BankID":        4,
+		"familie/MinID":  3,
+		"familie/BankID": 4,
+	}
+	for k, v := range want {
+		if sums[k] != v {
+			t.Errorf("ResampleMetrics() %v = %v, want %v", k, sums[k], v)
+		}
+	}
+	total := 0
+	for _, m := range got {
+		if m.Gruppering == "" {
+			total += m.Antall
+		}
+	}
+	if total != 7 {
+		t.Error("Resampled metrics should add up to the sum, got ", total)
+	}
+
+	weekly := ResampleMetrics(got, PeriodWeek)
+	if !reflect.DeepEqual(ResampleMetrics(metrics, PeriodWeek), weekly) {
+		t.Error("Resampling daily metrics should give the same weeks as hourly metrics")
+	}
+}
+
+func TestResampleOrgMetrics(t *testing.T) {
+	s := append(hours(OrgNr, 1, 0, 1, 2), hours(OldOrg, 2, 0, 1)...)
+	got := ResampleMetrics(Breakdown([]Org{OrgNr, OldOrg}, []Series{s[:3], s[3:]}).OrgMetrics(), PeriodDay)
+	sums := make(map[Org]int)
+	for _, m := range got {
+		if m.Metode == MinID {
+			sums[m.Org] += m.Antall
+		}
+	}
+	if len(got) != 2*len(Statistikk{}.ToMetrics()) || sums[OrgNr] != 3 || sums[OldOrg] != 4 {
+		t.Error("ResampleMetrics() should keep the organizations apart, got ", sums)
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	if Oslo == time.UTC {
+		t.Skip("No time zone database")
+	}
+	// Summer time, UTC+2.
+	if got := LocalTime(time.Date(2020, 5, 1, 22, 0, 0, 0, time.UTC)).String(); got != "2020-05-02T00:00:00" {
+		t.Error("LocalTime() in summer, got ", got)
+	}
+	// Winter time, UTC+1.
+	if got := LocalTime(time.Date(2020, 12, 31, 23, 0, 0, 0, time.UTC)).String(); got != "2021-01-01T00:00:00" {
+		t.Error("LocalTime() in winter, got ", got)
+	}
+	m := Statistikk{Timestamp: time.Date(2020, 5, 1, 22, 0, 0, 0, time.UTC)}.ToMetrics()
+	if m[0].Lokaltid != LocalTime(m[0].Timestamp) {
+		t.Error("ToMetrics() should set Lokaltid, got ", m[0])
+	}
+}
+
+func TestResampleInOslo(t *testing.T) {
+	if Oslo == time.UTC {
+		t.Skip("No time zone database")
+	}
+	// Every hour from 27 to 31 March 2020. Summer time started at 02:00 on
+	// Sunday 29 March, so that day has 23 hours.
+	s := make(Series, 0)
+	for ts := time.Date(2020, 3, 26, 23, 0, 0, 0, time.UTC); ts.Before(time.Date(2020, 3, 31, 22, 0, 0, 0, time.UTC)); ts = ts.Add(time.Hour) {
+		s = append(s, Statistikk{Timestamp: ts, Measurements: Measurements{KeyMinID: 1}})
+	}
+	days := s.ResampleIn(PeriodDay, Oslo)
+	if len(days) != 5 {
+		t.Fatal("ResampleIn() should give five Norwegian days, got ", len(days))
+	}
+	for i, want := range []int{24, 24, 23, 24, 24} {
+		if days[i].Sum != want {
+			t.Errorf("Day %v = %v hours, want %v", i, days[i].Sum, want)
+		}
+		if got := days[i].Timestamp.In(Oslo); got.Hour() != 0 || got.Day() != 27+i {
+			t.Errorf("Day %v should start at midnight in Oslo, got %v", i, got)
+		}
+		if days[i].Timestamp.Location() != time.UTC {
+			t.Error("Resampled timestamps should be in UTC, got ", days[i].Timestamp)
+		}
+	}
+
+	// Summer time ended at 03:00 on Sunday 25 October 2020, a 25 hour day.
+	s = make(Series, 0)
+	for ts := time.Date(2020, 10, 24, 22, 0, 0, 0, time.UTC); ts.Before(time.Date(2020, 10, 25, 23, 0, 0, 0, time.UTC)); ts = ts.Add(time.Hour) {
+		s = append(s, Statistikk{Timestamp: ts, Measurements: Measurements{KeyMinID: 1}})
+	}
+	if days := s.ResampleIn(PeriodDay, Oslo); len(days) != 1 || days[0].Sum != 25 {
+		t.Error("The last day of summer time should have 25 hours, got ", days)
+	}
+
+	// The hours from 00:00 and 01:00 UTC on 25 October 2020 both start at
+	// 02:00 in Oslo, first in summer and then in winter time.
+	twice := Series{
+		{Timestamp: time.Date(2020, 10, 25, 0, 30, 0, 0, time.UTC), Measurements: Measurements{KeyMinID: 1}},
+		{Timestamp: time.Date(2020, 10, 25, 1, 30, 0, 0, time.UTC), Measurements: Measurements{KeyMinID: 2}},
+	}
+	hourly := twice.ResampleIn(PeriodHour, Oslo)
+	if len(hourly) != 2 || !hourly[0].Timestamp.Equal(time.Date(2020, 10, 25, 0, 0, 0, 0, time.UTC)) || hourly[1].Sum != 2 {
+		t.Error("The two hours from 02:00 in Oslo should be kept apart, got ", hourly)
+	}
+	if got := ResampleMetricsIn(twice.Metrics(), PeriodHour, Oslo); len(got) != 2*len(twice[0].ToMetrics()) {
+		t.Error("The metrics of the two hours from 02:00 should be kept apart, got ", len(got))
+	}
+	india, err := time.LoadLocation("Asia/Kolkata")
+	if err == nil {
+		if got := PeriodHour.Truncate(time.Date(2020, 10, 25, 10, 45, 0, 0, india)); got.Minute() != 0 || got.Hour() != 10 {
+			t.Error("Truncate() should keep whole local hours, got ", got)
+		}
+	}
+
+	// The last hour of October in UTC, 23:00, is midnight on 1 November in
+	// Oslo, so it belongs to November.
+	last := Series{{Timestamp: time.Date(2020, 10, 31, 23, 0, 0, 0, time.UTC)}}
+	if got := last.ResampleIn(PeriodMonth, Oslo)[0].Timestamp; !got.Equal(time.Date(2020, 11, 1, 0, 0, 0, 0, Oslo)) {
+		t.Error("Month in Oslo, got ", got)
+	}
+	metrics := ResampleMetricsIn(last.Metrics(), PeriodMonth, Oslo)
+	if got := metrics[0].Lokaltid.String(); got != "2020-11-01T00:00:00" {
+		t.Error("Lokaltid should be the start of the month in Oslo, got ", got)
+	}
+}
@@ -0,0 +1,109 @@
+package idharvest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvFailOnUnknownMethod makes StreamLatestDataToBigQuery fail, without
+// writing anything, when the API returns an authentication method which is
+// not known to this package. Set it to "true" in the function's
+// environment:
+//
+//	gcloud functions deploy ... --set-env-vars IDHARVEST_FAIL_ON_UNKNOWN_METHOD=true
+const EnvFailOnUnknownMethod = "IDHARVEST_FAIL_ON_UNKNOWN_METHOD"
+
+// UnknownMethod describes a measurement key returned by the API which is
+// not known to this package, typically a new authentication method.
+type UnknownMethod struct {
+	Key    string    `json:"key"`
+	First  time.Time `json:"first"`  // Timestamp of the first row with the key.
+	Last   time.Time `json:"last"`   // Timestamp of the last row with the key.
+	Rows   int       `json:"rows"`   // Number of rows with the key.
+	Antall int       `json:"antall"` // Total logins with the method.
+}
+
+// UnknownMethodError is returned when unknown methods are not accepted,
+// see Client.StrictMethods and EnvFailOnUnknownMethod.
+type UnknownMethodError struct {
+	Methods []UnknownMethod
+}
+
+func (e *UnknownMethodError) Error() string {
+	keys := make([]string, 0, len(e.Methods))
+	for _, m := range e.Methods {
+		keys = append(keys, fmt.Sprintf("%q (first seen %v)", m.Key, DateToString(m.First)))
+	}
+	return "idharvest: unknown authentication methods: " + strings.Join(keys, ", ")
+}
+
+// FindUnknownMethods returns the unknown methods in stat, sorted by key.
+func FindUnknownMethods(stat []Statistikk) []UnknownMethod {
+	found := make(map[string]*UnknownMethod)
+	for _, s := range stat {
+		for _, k := range s.Measurements.Unknown() {
+			m, ok := found[k]
+			if !ok {
+				m = &UnknownMethod{Key: k, First: s.Timestamp, Last: s.Timestamp}
+				found[k] = m
+			}
+			if s.Timestamp.Before(m.First) {
+				m.First = s.Timestamp
+			}
+			if s.Timestamp.After(m.Last) {
+				m.Last = s.Timestamp
+			}
+			m.Rows++
+			m.Antall += s.Measurements[k]
+		}
+	}
+	methods := make([]UnknownMethod, 0, len(found))
+	for _, m := range found {
+		methods = append(methods, *m)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Key < methods[j].Key
+	})
+	return methods
+}
+
+// RunResult summarises a harvest.
+type RunResult struct {
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	Rows           int             `json:"rows"`           // Statistikk rows written.
+	Metrics        int             `json:"metrics"`        // Metric rows written.
+	GroupedMetrics int             `json:"groupedMetrics"` // Grouped Metric rows written.
+	OrgMetrics     int             `json:"orgMetrics"`     // Metric rows by organization written.
+	UnknownMethods []UnknownMethod `json:"unknownMethods,omitempty"`
+}
+
+// Log writes the result to w as a structured log entry in the JSON format
+// understood by Cloud Logging. Unknown methods raise the severity to
+// WARNING so that they can be alerted on.
+func (r *RunResult) Log(w io.Writer) error {
+	entry := struct {
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+		*RunResult
+	}{
+		Severity:  "INFO",
+		Message:   fmt.Sprintf("Harvested %v rows and %v metrics from %v to %v", r.Rows, r.Metrics, DateToString(r.From), DateToString(r.To)),
+		RunResult: r,
+	}
+	if len(r.UnknownMethods) > 0 {
+		entry.Severity = "WARNING"
+		entry.Message += ", found unknown authentication methods"
+	}
+	return json.NewEncoder(w).Encode(entry)
+}
+
+// failOnUnknownMethod reports whether EnvFailOnUnknownMethod is set.
+func failOnUnknownMethod() bool {
+	return os.Getenv(EnvFailOnUnknownMethod) == "true"
+}
@@ -0,0 +1,80 @@
+package idharvest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindUnknownMethods(t *testing.T) {
+	t0 := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	stat := []Statistikk{
+		{Timestamp: t0.Add(time.Hour), Measurements: Measurements{KeyMinID: 1, "Foo ID": 2}},
+		{Timestamp: t0, Measurements: Measurements{KeyMinID: 1, "Foo ID": 3, "Bar": 1}},
+		{Timestamp: t0.Add(2 * time.Hour), Measurements: Measurements{KeyMinID: 1}},
+	}
+	want := []UnknownMethod{
+		{Key: "Bar", First: t0, Last: t0, Rows: 1, Antall: 1},
+		{Key: "Foo ID", First: t0, Last: t0.Add(time.Hour), Rows: 2, Antall: 5},
+	}
+	if got := FindUnknownMethods(stat); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindUnknownMethods() = %v, want %v", got, want)
+	}
+	if got := FindUnknownMethods(stat[2:]); len(got) != 0 {
+		t.Error("Expected no unknown methods, got ", got)
+	}
+}
+
+func TestRunResultLog(t *testing.T) {
+	r := &RunResult{
+		Rows: 2,
+		UnknownMethods: []UnknownMethod{
+			{Key: "Foo ID", Rows: 2, Antall: 5},
+		},
+	}
+	var buf bytes.Buffer
+	if err := r.Log(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["severity"] != "WARNING" {
+		t.Error("Unknown methods should be logged as a warning: ", buf.String())
+	}
+	if _, ok := entry["unknownMethods"]; !ok {
+		t.Error("Missing unknownMethods: ", buf.String())
+	}
+}
+
+func TestClientStrictMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1,"Foo ID":2}}]`))
+	}))
+	defer srv.Close()
+
+	opts := QueryOptions{
+		From: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+	}
+	c := &Client{BaseURL: srv.URL}
+	if _, err := c.Fetch(context.Background(), opts); err != nil {
+		t.Fatal("Unknown methods should be accepted by default: ", err)
+	}
+	c.StrictMethods = true
+	_, err := c.Fetch(context.Background(), opts)
+	var unknownErr *UnknownMethodError
+	if !errors.As(err, &unknownErr) {
+		t.Fatal("Expected *UnknownMethodError, got ", err)
+	}
+	if len(unknownErr.Methods) != 1 || unknownErr.Methods[0].Key != "Foo ID" {
+		t.Error("Incorrect methods: ", unknownErr.Methods)
+	}
+}
@@ -0,0 +1,161 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides how often and how patiently a failed request is
+// repeated. Requests are retried on network errors and on the status codes
+// in RetryableStatus, never on decode errors, unknown methods or other 4xx
+// responses.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential growth of the wait.
+	MaxBackoff time.Duration
+	// MaxRetryAfter caps the wait asked for by a Retry-After from the
+	// server, MaxBackoff if zero.
+	MaxRetryAfter time.Duration
+	// Multiplier grows the wait between each attempt, 2 if zero.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of each wait that is
+	// randomised so that parallel harvesters don't retry in lockstep.
+	Jitter float64
+	// RetryableStatus lists the status codes worth retrying. If nil, 429
+	// and 5xx responses are retried.
+	RetryableStatus []int
+}
+
+// DefaultRetryPolicy is used by clients without a RetryPolicy. It gives the
+// API roughly half a minute to recover.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     15 * time.Second,
+	MaxRetryAfter:  time.Minute,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+// NoRetry makes a single attempt.
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// permanent marks an error which must not be retried.
+type permanent struct {
+	err error
+}
+
+func (e permanent) Error() string {
+	return e.err.Error()
+}
+
+// retryable reports whether err from the given attempt should be retried.
+func (p *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if p.RetryableStatus == nil {
+			return apiErr.Temporary()
+		}
+		for _, code := range p.RetryableStatus {
+			if apiErr.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+	var decErr *DecodeError
+	if errors.As(err, &decErr) {
+		return false
+	}
+	var unknownErr *UnknownMethodError
+	if errors.As(err, &unknownErr) {
+		return false
+	}
+	// Anything else comes from the transport: refused or reset connections,
+	// timeouts and truncated bodies.
+	return true
+}
+
+// backoff returns the wait before retry number n, counting from zero. A
+// Retry-After from the server is honoured, up to MaxRetryAfter, if it asks
+// for a longer wait.
+func (p *RetryPolicy) backoff(n int, retryAfter time.Duration) time.Duration {
+	maxRetryAfter := p.MaxRetryAfter
+	if maxRetryAfter == 0 {
+		maxRetryAfter = p.MaxBackoff
+	}
+	if maxRetryAfter > 0 && retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	mult := p.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(n))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	if wait := time.Duration(d); wait > retryAfter {
+		return wait
+	}
+	return retryAfter
+}
+
+// do calls attempt until it succeeds, fails permanently, the attempts are
+// used up or ctx is done. In the last case ctx.Err() is returned.
+func (p *RetryPolicy) do(ctx context.Context, attempt func() error) (err error) {
+	for n := 0; ; n++ {
+		err = attempt()
+		if perm, ok := err.(permanent); ok {
+			return perm.err
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || n+1 >= p.MaxAttempts || !p.retryable(ctx, err) {
+			return err
+		}
+		var retryAfter time.Duration
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(p.backoff(n, retryAfter))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		status       int
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "Recovers after two 503", failures: 2, status: http.StatusServiceUnavailable, wantAttempts: 3},
+		{name: "Recovers after a 429", failures: 1, status: http.StatusTooManyRequests, wantAttempts: 2},
+		{name: "Gives up after max attempts", failures: 10, status: http.StatusBadGateway, wantErr: true, wantAttempts: 4},
+		{name: "Bad request is permanent", failures: 10, status: http.StatusBadRequest, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&attempts, 1) <= tt.failures {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte(`[{"timestamp":"2020-05-01T00:00:00Z","measurements":{"MinID":1}}]`))
+			}))
+			defer srv.Close()
+
+			c := &Client{BaseURL: srv.URL, Retry: &RetryPolicy{
+				MaxAttempts:    4,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     5 * time.Millisecond,
+				Jitter:         0.5,
+			}}
+			_, err := c.Query(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+				OrgNr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts = %v, want %v", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for n, w := range want {
+		if got := p.backoff(n, 0); got != w {
+			t.Errorf("backoff(%v) = %v, want %v", n, got, w)
+		}
+	}
+	if got := p.backoff(0, time.Minute); got != 5*time.Second {
+		t.Errorf("Retry-After should be capped at MaxBackoff, got %v", got)
+	}
+	p.MaxRetryAfter = 2 * time.Minute
+	if got := p.backoff(0, time.Minute); got != time.Minute {
+		t.Errorf("Retry-After was not honoured, got %v", got)
+	}
+	if got := p.backoff(0, time.Hour); got != 2*time.Minute {
+		t.Errorf("Retry-After should be capped at MaxRetryAfter, got %v", got)
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1, 0); got < time.Second || got > 2*time.Second {
+			t.Fatalf("backoff with jitter out of range: %v", got)
+		}
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.QueryContext(ctx, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		OrgNr)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("A retry cancelled while waiting should return ctx.Err(), got ", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"Fri, 01 May 2020 12:00:30 GMT", 30 * time.Second},
+		{"Fri, 01 May 2020 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package idharvest
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Series is a time series of Statistikk. A []Statistikk from the query
+// functions converts to a Series without copying:
+//
+//	series := idharvest.Series(stat).Dedup()
+type Series []Statistikk
+
+// Gap is a period without values in a Series, from the first missing to the
+// last missing timestamp.
+type Gap struct {
+	From time.Time
+	To   time.Time
+}
+
+// Sort orders s by timestamp in place. Values with the same timestamp keep
+// their order.
+func (s Series) Sort() {
+	sort.SliceStable(s, func(i, j int) bool {
+		return s[i].Timestamp.Before(s[j].Timestamp)
+	})
+}
+
+// IsSorted reports whether s is ordered by timestamp.
+func (s Series) IsSorted() bool {
+	return sort.SliceIsSorted(s, func(i, j int) bool {
+		return s[i].Timestamp.Before(s[j].Timestamp)
+	})
+}
+
+// Dedup returns s sorted by timestamp with repeated values for the same
+// timestamp and categories removed, keeping the first, for instance where
+// the periods of two queries overlap.
+func (s Series) Dedup() Series {
+	type key struct {
+		unix       int64
+		categories string
+	}
+	keyOf := func(v Statistikk) key {
+		b, _ := json.Marshal(v.Categories)
+		return key{v.Timestamp.Unix(), string(b)}
+	}
+	seen := make(map[key]bool, len(s))
+	unique := make(Series, 0, len(s))
+	for _, v := range s {
+		k := keyOf(v)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		unique = append(unique, v)
+	}
+	unique.Sort()
+	return unique
+}
+
+// Merge returns the values of s and o summed by timestamp, with Sum
+// recalculated, ordered by timestamp. It is used to combine the series of
+// two organization numbers into one. A merged value keeps the timestamp and
+// categories of the first value seen for its timestamp, s before o.
+//
+// Values with the same timestamp are summed even within s, so remove
+// duplicates with Dedup first.
+func (s Series) Merge(o Series) Series {
+	index := make(map[int64]int, len(s))
+	merged := make(Series, 0, len(s))
+	for _, series := range []Series{s, o} {
+		for _, v := range series {
+			i, ok := index[v.Timestamp.Unix()]
+			if !ok {
+				index[v.Timestamp.Unix()] = len(merged)
+				merged = append(merged, v.CalcSum())
+				continue
+			}
+			merged[i] = merged[i].Add(v).CalcSum()
+		}
+	}
+	merged.Sort()
+	return merged
+}
+
+// Between returns the values from and including from until but excluding
+// to. s must be sorted. The result shares its elements with s.
+func (s Series) Between(from time.Time, to time.Time) Series {
+	start := sort.Search(len(s), func(i int) bool {
+		return !s[i].Timestamp.Before(from)
+	})
+	end := sort.Search(len(s), func(i int) bool {
+		return !s[i].Timestamp.Before(to)
+	})
+	if end < start {
+		end = start
+	}
+	return s[start:end]
+}
+
+// Gaps returns the periods where s, which must be sorted, has no values
+// although one is expected every step, for instance every hour.
+func (s Series) Gaps(step time.Duration) []Gap {
+	gaps := make([]Gap, 0)
+	for i := 1; i < len(s); i++ {
+		if s[i].Timestamp.Sub(s[i-1].Timestamp) > step {
+			gaps = append(gaps, Gap{
+				From: s[i-1].Timestamp.Add(step),
+				To:   s[i].Timestamp.Add(-step),
+			})
+		}
+	}
+	return gaps
+}
+
+// Span returns the first and the last timestamp of s, which must be sorted.
+// ok is false if s is empty.
+func (s Series) Span() (first time.Time, last time.Time, ok bool) {
+	if len(s) == 0 {
+		return
+	}
+	return s[0].Timestamp, s[len(s)-1].Timestamp, true
+}
+
+// Each calls fn for each value in order, stopping at the first error.
+func (s Series) Each(fn func(Statistikk) error) error {
+	for _, v := range s {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metrics returns the metrics of all values, see Statistikk.ToMetrics.
+func (s Series) Metrics() []Metric {
+	metrics := make([]Metric, 0, len(s)*len(Methods))
+	for _, v := range s {
+		metrics = append(metrics, v.ToMetrics()...)
+	}
+	return metrics
+}
+
+// OrgMetrics returns the metrics of all values with Org set from
+// Categories.TEOrgnum, for the breakdown by organization. Use it on the
+// series from Breakdown.
+func (s Series) OrgMetrics() []Metric {
+	metrics := make([]Metric, 0, len(s)*len(Methods))
+	for _, v := range s {
+		for _, m := range v.ToMetrics() {
+			m.Org = Org(v.Categories.TEOrgnum)
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// GroupedMetrics returns the grouped metrics of all values, see
+// Statistikk.ToGroupedMetrics.
+func (s Series) GroupedMetrics(groupings ...Grouping) []Metric {
+	metrics := make([]Metric, 0)
+	for _, v := range s {
+		metrics = append(metrics, v.ToGroupedMetrics(groupings...)...)
+	}
+	return metrics
+}
@@ -0,0 +1,90 @@
+package idharvest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// hours returns a series with one value for each of the given hours after
+// midnight 1 May 2020, with MinID set to n.
+func hours(org Org, n int, hs ...int) Series {
+	s := make(Series, 0, len(hs))
+	for _, h := range hs {
+		v := Statistikk{
+			Timestamp:    time.Date(2020, 5, 1, h, 0, 0, 0, time.UTC),
+			Measurements: Measurements{KeyMinID: n},
+		}
+		v.Categories.TEOrgnum = string(org)
+		s = append(s, v)
+	}
+	return s
+}
+
+func timestamps(s Series) []int {
+	hs := make([]int, 0, len(s))
+	for _, v := range s {
+		hs = append(hs, v.Timestamp.Hour())
+	}
+	return hs
+}
+
+func TestSeriesDedup(t *testing.T) {
+	s := append(hours(OrgNr, 1, 3, 1, 2), hours(OrgNr, 2, 2, 0)...)
+	s = append(s, hours(OldOrg, 3, 2)...)
+	got := s.Dedup()
+	if want := []int{0, 1, 2, 2, 3}; !reflect.DeepEqual(timestamps(got), want) {
+		t.Errorf("Dedup() = %v, want %v", timestamps(got), want)
+	}
+	if got[2].Measurements.MinID() != 1 {
+		t.Error("Dedup() should keep the first value, got ", got[2])
+	}
+	if !got.IsSorted() || s.IsSorted() {
+		t.Error("IsSorted() is wrong")
+	}
+}
+
+func TestSeriesMerge(t *testing.T) {
+	large := hours(OrgNr, 1, 0, 1, 2, 3)
+	small := hours(OldOrg, 10, 2, 3, 4)
+	got := large.Merge(small)
+	if want := []int{0, 1, 2, 3, 4}; !reflect.DeepEqual(timestamps(got), want) {
+		t.Fatalf("Merge() = %v, want %v", timestamps(got), want)
+	}
+	for i, want := range []int{1, 1, 11, 11, 10} {
+		if got[i].Measurements.MinID() != want || got[i].Sum != want {
+			t.Errorf("Merge()[%v] = %v, want %v", i, got[i], want)
+		}
+	}
+	if got[2].Categories.TEOrgnum != string(OrgNr) {
+		t.Error("Merged values should keep the categories of the first series")
+	}
+	if large[2].Measurements.MinID() != 1 {
+		t.Error("Merge() changed its input")
+	}
+}
+
+func TestSeriesBetweenAndGaps(t *testing.T) {
+	s := hours(OrgNr, 1, 0, 1, 2, 5, 6, 9)
+	between := s.Between(time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC), time.Date(2020, 5, 1, 6, 0, 0, 0, time.UTC))
+	if want := []int{1, 2, 5}; !reflect.DeepEqual(timestamps(between), want) {
+		t.Errorf("Between() = %v, want %v", timestamps(between), want)
+	}
+	if got := s.Between(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); len(got) != 0 {
+		t.Error("Between() with to before from should be empty, got ", got)
+	}
+	want := []Gap{
+		{From: time.Date(2020, 5, 1, 3, 0, 0, 0, time.UTC), To: time.Date(2020, 5, 1, 4, 0, 0, 0, time.UTC)},
+		{From: time.Date(2020, 5, 1, 7, 0, 0, 0, time.UTC), To: time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	if got := s.Gaps(time.Hour); !reflect.DeepEqual(got, want) {
+		t.Errorf("Gaps() = %v, want %v", got, want)
+	}
+	first, last, ok := s.Span()
+	if !ok || first.Hour() != 0 || last.Hour() != 9 {
+		t.Error("Span() = ", first, last, ok)
+	}
+	if _, _, ok := (Series{}).Span(); ok {
+		t.Error("Span() of an empty series should not be ok")
+	}
+}
@@ -0,0 +1,129 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Sink stores harvested data. The tables are named like in BigQuery: the
+// series go to the table "nav" and metrics to MetricsTableName,
+// GroupedMetricsTableName and OrgMetricsTableName.
+type Sink interface {
+	// Latest returns the timestamp of the last hour in the metrics table,
+	// the high-water mark of the incremental stream. It returns the zero
+	// time if nothing is stored.
+	Latest(ctx context.Context) (time.Time, error)
+	// WriteSeries appends values to the series table.
+	WriteSeries(ctx context.Context, series Series) error
+	// WriteMetrics appends metrics to the named metrics table.
+	WriteMetrics(ctx context.Context, table string, metrics []Metric) error
+	// Reset removes everything stored, before a rebuild.
+	Reset(ctx context.Context) error
+	// Flush is called after all values of a run are written.
+	Flush(ctx context.Context) error
+}
+
+// ErrEmptySink is returned by Harvester.Stream when the sink has no
+// high-water mark to continue from. Rebuild it first.
+var ErrEmptySink = errors.New("idharvest: nothing stored in sink, rebuild first")
+
+// Stream harvests everything after the high-water mark of sink and writes
+// it. If strict is set nothing is written when unknown methods are found,
+// and an UnknownMethodError is returned. The result is nil if there is
+// nothing new to harvest.
+func (h *Harvester) Stream(ctx context.Context, sink Sink, strict bool) (result *RunResult, err error) {
+	latest, err := sink.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if latest.IsZero() {
+		return nil, ErrEmptySink
+	}
+
+	// I assume we get so little data that we can gather it all in one go.
+	// we could reload everything if discrepancies arise over time.
+	fromTime := latest.Add(time.Hour)
+	toTime := h.now().UTC()
+	if fromTime.After(toTime) {
+		// Sanity check failed. If we run collection too fast, we
+		// shouldn´t do anyting.
+		return nil, nil
+	}
+
+	orgs, perOrg, err := h.HarvestOrgs(ctx, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	series := h.MergeSeries(orgs, perOrg)
+	result = &RunResult{
+		From:           fromTime,
+		To:             toTime,
+		UnknownMethods: FindUnknownMethods(series),
+	}
+	if strict && len(result.UnknownMethods) > 0 {
+		return result, &UnknownMethodError{Methods: result.UnknownMethods}
+	}
+	return result, write(ctx, sink, result, series, Breakdown(orgs, perOrg))
+}
+
+// Rebuild replaces everything in sink with all data of all organizations.
+func (h *Harvester) Rebuild(ctx context.Context, sink Sink) (result *RunResult, err error) {
+	toTime := h.now().UTC()
+	log.Println("Read the data of all organizations from the API.")
+	orgs, perOrg, err := h.HarvestOrgs(ctx, time.Time{}, toTime)
+	if err != nil {
+		return nil, err
+	}
+	series := h.MergeSeries(orgs, perOrg)
+	log.Printf("Read a total of %v values, merged with %v", len(series), h.merge().Name)
+
+	for _, gap := range series.Gaps(time.Hour) {
+		log.Printf("Warning: no data from %v to %v", gap.From, gap.To)
+	}
+	result = &RunResult{
+		To:             toTime,
+		UnknownMethods: FindUnknownMethods(series),
+	}
+	for _, m := range result.UnknownMethods {
+		log.Printf("Warning: unknown authentication method %q from %v to %v, %v logins", m.Key, m.First, m.Last, m.Antall)
+	}
+	if first, _, ok := series.Span(); ok {
+		result.From = first
+	}
+
+	if err := sink.Reset(ctx); err != nil {
+		return result, err
+	}
+	return result, write(ctx, sink, result, series, Breakdown(orgs, perOrg))
+}
+
+// write writes the merged series, its metrics and the breakdown by
+// organization to sink, counting the rows in result.
+func write(ctx context.Context, sink Sink, result *RunResult, series Series, breakdown Series) error {
+	metrics := series.Metrics()
+	if err := sink.WriteMetrics(ctx, MetricsTableName, metrics); err != nil {
+		return err
+	}
+	result.Metrics = len(metrics)
+
+	grouped := series.GroupedMetrics(Groupings...)
+	if err := sink.WriteMetrics(ctx, GroupedMetricsTableName, grouped); err != nil {
+		return err
+	}
+	result.GroupedMetrics = len(grouped)
+
+	orgMetrics := breakdown.OrgMetrics()
+	if err := sink.WriteMetrics(ctx, OrgMetricsTableName, orgMetrics); err != nil {
+		return err
+	}
+	result.OrgMetrics = len(orgMetrics)
+
+	if err := sink.WriteSeries(ctx, series); err != nil {
+		return err
+	}
+	result.Rows = len(series)
+
+	return sink.Flush(ctx)
+}
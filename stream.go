@@ -0,0 +1,111 @@
+package idharvest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// FetchEach is like Fetch but calls fn for each Statistikk as it is decoded
+// from the response instead of collecting them, so a response of any size
+// is handled in constant memory. An error from fn stops the decoding and is
+// returned unchanged.
+//
+// The request is retried like Fetch until fn has been called; after that a
+// failure is returned rather than giving fn the same values twice.
+func (c *Client) FetchEach(ctx context.Context, opts QueryOptions, fn func(Statistikk) error) error {
+	queryURL := c.queryURL(opts)
+	emitted := false
+	return c.retryPolicy().do(ctx, func() error {
+		err := c.get(ctx, queryURL, func(s Statistikk) error {
+			emitted = true
+			return fn(s)
+		})
+		if err != nil && emitted {
+			return permanent{err}
+		}
+		return err
+	})
+}
+
+// QueryRangeEach is like QueryRange but calls fn for each Statistikk in
+// order of time, reading one window at a time. Memory use does not grow
+// with the length of the period. With GroupBy or Categories there are
+// several values for each hour, all of which are passed on.
+func (c *Client) QueryRangeEach(ctx context.Context, opts QueryOptions, fn func(Statistikk) error) error {
+	for _, w := range splitWindows(opts.From, opts.To, c.WindowMonths, opts.Granularity) {
+		windowOpts := opts
+		windowOpts.From, windowOpts.To = w.From, w.To
+		if err := c.FetchEach(ctx, windowOpts, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryRangeEach is a shorthand for DefaultClient.QueryRangeEach.
+func QueryRangeEach(ctx context.Context, opts QueryOptions, fn func(Statistikk) error) error {
+	return DefaultClient.QueryRangeEach(ctx, opts, fn)
+}
+
+// decodeEach decodes a JSON array of Statistikk from r one element at a
+// time. Malformed JSON is reported as a *DecodeError, failures to read r
+// are returned as they are so that they may be retried.
+func decodeEach(queryURL string, r io.Reader, fn func(Statistikk) error) error {
+	cr := &captureReader{r: r}
+	dec := json.NewDecoder(cr)
+	decodeErr := func(err error) error {
+		if cr.err != nil && cr.err != io.EOF {
+			return cr.err
+		}
+		return &DecodeError{URL: queryURL, Payload: cr.prefix, Err: err}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return decodeErr(err)
+	}
+	if tok == nil {
+		// null, the same as an empty array.
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return decodeErr(errors.New("expected an array of statistics"))
+	}
+	for dec.More() {
+		var s Statistikk
+		if err := dec.Decode(&s); err != nil {
+			return decodeErr(err)
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return decodeErr(err)
+	}
+	return nil
+}
+
+// captureReader keeps the first excerptSize bytes read and the last read
+// error.
+type captureReader struct {
+	r      io.Reader
+	prefix []byte
+	err    error
+}
+
+func (c *captureReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	if room := excerptSize - len(c.prefix); room > 0 {
+		if room > n {
+			room = n
+		}
+		c.prefix = append(c.prefix, p[:room]...)
+	}
+	if err != nil {
+		c.err = err
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package idharvest
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecodeEach(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		want       int
+		decodeErr  bool
+		wantPrefix string
+	}{
+		{name: "Empty array", body: `[]`, want: 0},
+		{name: "Null", body: `null`, want: 0},
+		{name: "Two elements", body: `[{"timestamp":"2020-05-01T00:00:00Z"},{"timestamp":"2020-05-01T01:00:00Z"}]`, want: 2},
+		{name: "Truncated", body: `[{"timestamp":"2020-05-01T00:00:00Z"},{"timest`, want: 1, decodeErr: true},
+		{name: "HTML", body: `<html>Maintenance</html>`, want: 0, decodeErr: true, wantPrefix: "<html>"},
+		{name: "Object", body: `{"error":"no"}`, want: 0, decodeErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			err := decodeEach("http://example", strings.NewReader(tt.body), func(Statistikk) error {
+				got++
+				return nil
+			})
+			var decErr *DecodeError
+			if errors.As(err, &decErr) != tt.decodeErr {
+				t.Errorf("decodeEach() error = %v, want DecodeError %v", err, tt.decodeErr)
+			}
+			if decErr != nil && !strings.HasPrefix(string(decErr.Payload), tt.wantPrefix) {
+				t.Errorf("Payload = %q, want prefix %q", decErr.Payload, tt.wantPrefix)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %v elements, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchEachStopsOnCallbackError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(hourlyHandler(t, &calls))
+	defer srv.Close()
+
+	stop := errors.New("stop")
+	c := &Client{BaseURL: srv.URL}
+	seen := 0
+	err := c.FetchEach(context.Background(), QueryOptions{
+		From: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+	}, func(Statistikk) error {
+		seen++
+		if seen == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Error("Expected the callback's error, got ", err)
+	}
+	if seen != 3 || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("seen = %v, calls = %v, want 3 and 1", seen, calls)
+	}
+}
+
+func TestQueryRangeEach(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(hourlyHandler(t, &calls))
+	defer srv.Close()
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 3, 0)
+	c := &Client{BaseURL: srv.URL, WindowMonths: 1}
+	var last time.Time
+	n := 0
+	err := c.QueryRangeEach(context.Background(), QueryOptions{From: from, To: to}, func(s Statistikk) error {
+		if n > 0 && !s.Timestamp.After(last) {
+			t.Fatal("Out of order or duplicate: ", s.Timestamp)
+		}
+		last = s.Timestamp
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(to.Sub(from)/time.Hour) + 1; n != want {
+		t.Errorf("Got %v hours, want %v", n, want)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 3 {
+		t.Error("Expected 3 calls, got ", calls)
+	}
+}